@@ -2,16 +2,29 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/lianshufeng/proxy-pool/internal/acl"
+	"github.com/lianshufeng/proxy-pool/internal/admin"
+	"github.com/lianshufeng/proxy-pool/internal/appender"
+	"github.com/lianshufeng/proxy-pool/internal/auth"
 	"github.com/lianshufeng/proxy-pool/internal/config"
 	"github.com/lianshufeng/proxy-pool/internal/fetcher"
+	"github.com/lianshufeng/proxy-pool/internal/metrics"
 	"github.com/lianshufeng/proxy-pool/internal/pool"
+	"github.com/lianshufeng/proxy-pool/internal/probe"
 	"github.com/lianshufeng/proxy-pool/internal/server"
+	"github.com/lianshufeng/proxy-pool/internal/upstream"
+	"go.uber.org/zap"
 )
 
 func main() {
@@ -22,67 +35,99 @@ func main() {
 	log.Println("[BOOT] ===== proxy-pool starting (this banner proves you are running the new binary) =====")
 
 	cfg := config.Parse()
-	if cfg.APIURL == "" {
-		log.Fatal("missing --api-url")
+	pt, err := build(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, src := range pt.sources {
+		log.Printf("[BOOT] source=%s url=%s interval=%s ttl=%s weight=%d tag=%q", src.Name, src.URL, src.Interval, src.TTL, src.Weight, src.Tag)
 	}
 
-	pl := pool.New()
-	ft := fetcher.New(cfg.APIURL, cfg.DialTimeout)
+	pl := pool.New(pt.pool)
+	app := appender.New(pl, pt.sources, cfg.DialTimeout, pt.appender)
 
-	srv := server.New(server.Options{
-		Listen:              cfg.Listen,
-		Pool:                pl,
-		DialTimeout:         cfg.DialTimeout,
-		IdleConns:           cfg.IdleConn,
-		IdleTimeout:         cfg.IdleTimeout,
-		TLSHandshakeTimeout: cfg.HandshakeTimeout,
-	})
+	so := pt.server
+	so.Listen = cfg.Listen
+	so.SocksListen = cfg.SocksListen
+	so.Pool = pl
+	so.DialTimeout = cfg.DialTimeout
+	so.IdleConns = cfg.IdleConn
+	so.IdleTimeout = cfg.IdleTimeout
+	so.TLSHandshakeTimeout = cfg.HandshakeTimeout
+	so.OnEmpty = app.FetchNow // 严格模式下池子为空时，通知各来源立即拉取一次
+	srv := server.New(so)
 
-	log.Printf("[BOOT] listen=%s api-url=%s append-interval=%s ttl=%s", cfg.Listen, cfg.APIURL, cfg.AppendInterval, cfg.TTL)
+	log.Printf("[BOOT] listen=%s socks-listen=%q sources=%d append-interval=%s ttl=%s strategy=%s auth-users=%d", cfg.Listen, cfg.SocksListen, len(pt.sources), cfg.AppendInterval, cfg.TTL, cfg.Strategy, so.Users.Len())
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// 每隔 append-interval 追加 1 个代理
-	go func() {
-		iv := cfg.AppendInterval
-		if iv <= 0 {
-			iv = 10 * time.Second
+	rl := &reloader{
+		args:     os.Args[1:],
+		cfg:      cfg,
+		pool:     pl,
+		app:      app,
+		srv:      srv,
+		health:   &healthLoop{ctx: ctx, pool: pl},
+		sweep:    newInterval(cfg.SweepInterval),
+		snapshot: newInterval(cfg.SnapshotInterval),
+	}
+
+	// 恢复上次退出时的池子，避免重启后的首批请求无代理可用
+	if cfg.SnapshotFile != "" {
+		n, err := pl.LoadFile(cfg.SnapshotFile)
+		if err != nil {
+			log.Printf("[SNAPSHOT] restore %s failed: %v -> start empty", cfg.SnapshotFile, err)
+		} else {
+			log.Printf("[SNAPSHOT] restored %d proxies, %d bans from %s", n, len(pl.Bans()), cfg.SnapshotFile)
 		}
-		tk := time.NewTicker(iv)
-		defer tk.Stop()
-		for {
-			select {
-			case <-tk.C:
-				addr, err := ft.Next(ctx)
-				if err != nil {
-					log.Printf("[APPEND] fetch next failed: %v", err)
-					continue
-				}
-				pl.Add(addr, cfg.TTL)
-				log.Printf("[APPEND] added=%q size=%d", addr, pl.Size())
-			case <-ctx.Done():
-				return
+		// 间隔为 0 时只在退出时保存；热加载改为非 0 后开始定期保存
+		go every(ctx, rl.snapshot, func() {
+			if err := pl.SaveFile(cfg.SnapshotFile); err != nil {
+				log.Printf("[SNAPSHOT] save %s failed: %v", cfg.SnapshotFile, err)
 			}
-		}
-	}()
+		})
+	}
+
+	// 每个来源按自己的间隔追加一批代理，并维持池子规模
+	app.Run(ctx)
+
+	// 后台主动检测
+	rl.health.set(pt.health, cfg.HealthInterval)
 
 	// 定期清理过期项
-	go func() {
-		tk := time.NewTicker(30 * time.Second)
-		defer tk.Stop()
-		for {
-			select {
-			case <-tk.C:
-				before := pl.Size()
-				pl.Sweep()
-				after := pl.Size()
-				log.Printf("[SWEEP] before=%d after=%d", before, after)
-			case <-ctx.Done():
-				return
-			}
+	go every(ctx, rl.sweep, func() {
+		before := pl.Size()
+		pl.Sweep()
+		after := pl.Size()
+		log.Printf("[SWEEP] before=%d after=%d", before, after)
+	})
+
+	// 管理 API
+	var adm *admin.Server
+	if cfg.AdminListen != "" {
+		adm, err = admin.New(admin.Options{Listen: cfg.AdminListen, Token: cfg.AdminToken, Pool: pl, Appender: app, TTL: cfg.TTL, Reload: rl.Reload})
+		if err != nil {
+			log.Fatalf("invalid admin options: %v (set --admin-token)", err)
 		}
-	}()
+		go func() {
+			if err := adm.Start(); err != nil {
+				log.Printf("[EXIT] admin api stopped: %v", err)
+			}
+		}()
+	}
+
+	// Prometheus 指标
+	metrics.WatchPool(pl.Size, pl.Usable)
+	metrics.SetPerUpstream(cfg.MetricsPerUpstream)
+	zl, err := zap.NewProduction()
+	if err != nil {
+		zl = zap.NewNop()
+	}
+	ms := metrics.Start(zl, cfg.MetricsListen)
+	if ms != nil {
+		log.Printf("[BOOT] metrics on %s/metrics", cfg.MetricsListen)
+	}
 
 	// 启动代理
 	go func() {
@@ -92,11 +137,242 @@ func main() {
 		}
 	}()
 
-	// 优雅退出
+	// SIGHUP 重新加载配置；SIGINT/SIGTERM 优雅退出
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigCh {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		log.Printf("[RELOAD] SIGHUP received")
+		_, _ = rl.Reload()
+	}
 	log.Printf("[EXIT] shutting down...")
 	cancel()
 	_ = srv.Shutdown()
+	if cfg.SnapshotFile != "" {
+		if err := pl.SaveFile(cfg.SnapshotFile); err != nil {
+			log.Printf("[SNAPSHOT] save %s failed: %v", cfg.SnapshotFile, err)
+		} else {
+			log.Printf("[SNAPSHOT] saved %d proxies to %s", pl.Size(), cfg.SnapshotFile)
+		}
+	}
+	if ms != nil {
+		_ = ms.Close()
+	}
+	if adm != nil {
+		_ = adm.Shutdown()
+	}
+}
+
+// parts 由配置构造的各组件参数；启动与热加载共用。
+// server 只填了可热更新的字段，监听地址与连接池参数由 main 在启动时补齐
+type parts struct {
+	sources  []fetcher.Source
+	pool     pool.Options
+	server   server.Options
+	appender appender.Options
+	health   *probe.Prober // 后台检测；未开启为 nil
+}
+
+// build 按配置构造各组件参数；cfg 已由 config 校验，这里的错误只来自读取文件（用户、CA 证书）
+func build(cfg *config.Config) (parts, error) {
+	var pt parts
+	var err error
+	if pt.sources, err = buildSources(cfg); err != nil {
+		return pt, err
+	}
+	sel, err := pool.NewSelector(cfg.Strategy)
+	if err != nil {
+		return pt, fmt.Errorf("invalid --strategy: %w", err)
+	}
+	minAnon, _ := pool.ParseAnonymity(cfg.MinAnonymity) // 已由 config 校验
+	pt.pool = pool.Options{
+		FailThreshold: cfg.FailThreshold,
+		FailWindow:    cfg.FailWindow,
+		Quarantine:    cfg.Quarantine,
+		Selector:      sel,
+		MinTTL:        cfg.ExpiryMin,
+		MaxTTL:        cfg.ExpiryMax,
+		ExpiryMargin:  cfg.ExpiryMargin,
+		ExitIPDedup:   cfg.ExitIPDedup,
+		MinAnonymity:  minAnon,
+	}
+
+	users, err := auth.Load(cfg.AuthFile, cfg.AuthUsers)
+	if err != nil {
+		return pt, fmt.Errorf("load auth users: %w", err)
+	}
+	srcACL, err := acl.NewSourceACL(cfg.AllowCIDRs, cfg.DenyCIDRs)
+	if err != nil {
+		return pt, fmt.Errorf("invalid source acl: %w", err)
+	}
+	rules, err := acl.ParseRules(cfg.Rules, cfg.DialTimeout, cfg.RuleFailClosed)
+	if err != nil {
+		return pt, fmt.Errorf("invalid --rule: %w", err)
+	}
+	upTLS, err := upstream.TLSConfig(cfg.UpstreamCA, cfg.UpstreamSNI, cfg.UpstreamInsecure)
+	if err != nil {
+		return pt, fmt.Errorf("invalid upstream tls options: %w", err)
+	}
+	srcTLS, err := sourceTLS(pt.sources)
+	if err != nil {
+		return pt, fmt.Errorf("invalid source tls options: %w", err)
+	}
+	pt.server = server.Options{
+		SessionTTL:   cfg.SessionTTL,
+		SessionByIP:  cfg.SessionByIP,
+		Retries:      cfg.Retries,
+		RetryTimeout: cfg.RetryTimeout,
+		AllowDirect:  cfg.AllowDirect,
+		Strict:       cfg.Strict,
+		EmptyWait:    cfg.EmptyWait,
+		Users:        users,
+		AuthRealm:    cfg.AuthRealm,
+		SourceACL:    srcACL,
+		SelectKeys:   selectKeys(pt.sources),
+		Rules:        rules,
+
+		UpstreamTLS: upTLS,
+		SourceTLS:   srcTLS,
+	}
+
+	probers, err := buildProbers(cfg, upTLS, srcTLS)
+	if err != nil {
+		return pt, fmt.Errorf("invalid check options: %w", err)
+	}
+	pt.appender = appender.Options{
+		MinSize:   cfg.MinSize,
+		MaxSize:   cfg.MaxSize,
+		BatchSize: cfg.BatchSize,
+		Prober:    probers.admission,
+		ExitIP:    probers.exitIP,
+		Anonymity: probers.anonymity,
+	}
+	pt.health = probers.health
+	return pt, nil
+}
+
+// selectKeys 各来源映射声明的标签名；用户名中这些参数名（以及 country/city/isp/region）作为标签筛选
+func selectKeys(srcs []fetcher.Source) []string {
+	var keys []string
+	for _, src := range srcs {
+		for name := range src.Mapping.Labels {
+			if name = strings.ToLower(name); !slices.Contains(keys, name) {
+				keys = append(keys, name)
+			}
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// buildSources 汇总 --api-url 与 --source；未单独指定的间隔/TTL 取全局 --append-interval/--ttl
+func buildSources(cfg *config.Config) ([]fetcher.Source, error) {
+	iv := cfg.AppendInterval
+	if iv <= 0 {
+		iv = 10 * time.Second
+	}
+	def := fetcher.Source{Interval: iv, TTL: cfg.TTL, Weight: 1}
+
+	var srcs []fetcher.Source
+	if cfg.APIURL != "" {
+		src := def
+		src.Name, src.URL = "api", cfg.APIURL
+		srcs = append(srcs, src)
+	}
+	names := make(map[string]struct{})
+	for _, spec := range cfg.Sources {
+		src, err := fetcher.ParseSource(spec, def)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --source: %w", err)
+		}
+		srcs = append(srcs, src)
+	}
+	if len(srcs) == 0 {
+		return nil, errors.New("missing --api-url or --source")
+	}
+	for _, src := range srcs {
+		if _, dup := names[src.Name]; dup {
+			return nil, fmt.Errorf("duplicate source name %q", src.Name)
+		}
+		names[src.Name] = struct{}{}
+	}
+	return srcs, nil
+}
+
+// probers 各类检测的 Prober；未开启的为 nil
+type probers struct {
+	admission *probe.Prober // 入池检测（--check-url）
+	health    *probe.Prober // 后台检测（--health-url，默认 --check-url）
+	exitIP    *probe.Prober // 出口 IP 回显（--exit-ip-url）
+
+	anonymity *probe.Classifier // 匿名等级判定（--anonymity-url）
+}
+
+// buildProbers 按需创建各类检测的 Prober，全部共用 --check-concurrency 名额；参数间的依赖已由 config 校验
+func buildProbers(cfg *config.Config, upTLS *tls.Config, srcTLS map[string]*tls.Config) (probers, error) {
+	var ps probers
+	healthURL := cfg.HealthURL
+	if healthURL == "" {
+		healthURL = cfg.CheckURL
+	}
+
+	var base *probe.Prober
+	mk := func(rawURL string) (*probe.Prober, error) {
+		if base != nil {
+			return base.WithURL(rawURL)
+		}
+		var err error
+		base, err = probe.New(probe.Options{
+			URL:         rawURL,
+			Mode:        cfg.CheckMode,
+			Timeout:     cfg.CheckTimeout,
+			Concurrency: cfg.CheckConcurrency,
+			DialTimeout: cfg.DialTimeout,
+			UpstreamTLS: upTLS,
+			SourceTLS:   srcTLS,
+		})
+		return base, err
+	}
+	var err error
+	if cfg.CheckURL != "" {
+		if ps.admission, err = mk(cfg.CheckURL); err != nil {
+			return ps, err
+		}
+	}
+	if cfg.HealthInterval > 0 {
+		if ps.health, err = mk(healthURL); err != nil {
+			return ps, err
+		}
+	}
+	if cfg.ExitIPURL != "" {
+		if ps.exitIP, err = mk(cfg.ExitIPURL); err != nil {
+			return ps, err
+		}
+	}
+	if cfg.AnonymityURL != "" {
+		pr, err := mk(cfg.AnonymityURL)
+		if err != nil {
+			return ps, err
+		}
+		ps.anonymity = probe.NewClassifier(pr)
+	}
+	return ps, nil
+}
+
+// sourceTLS 为设置了来源级 TLS 选项的来源构造 https 上游的 TLS 配置
+func sourceTLS(srcs []fetcher.Source) (map[string]*tls.Config, error) {
+	m := make(map[string]*tls.Config)
+	for _, src := range srcs {
+		if !src.HasTLS() {
+			continue
+		}
+		cfg, err := upstream.TLSConfig(src.UpstreamCA, src.UpstreamSNI, src.UpstreamInsecure)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", src.Name, err)
+		}
+		m[src.Name] = cfg
+	}
+	return m, nil
 }
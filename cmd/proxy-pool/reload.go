@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/appender"
+	"github.com/lianshufeng/proxy-pool/internal/config"
+	"github.com/lianshufeng/proxy-pool/internal/metrics"
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+	"github.com/lianshufeng/proxy-pool/internal/probe"
+	"github.com/lianshufeng/proxy-pool/internal/server"
+)
+
+// reloader 持有当前生效的配置与可热更新的组件；SIGHUP 与管理 API 的 /reload 共用
+type reloader struct {
+	mu   sync.Mutex
+	args []string // 启动时的命令行参数，重新加载时按同样的优先级合并
+	cfg  *config.Config
+
+	pool     *pool.Pool
+	app      *appender.Appender
+	srv      *server.Server
+	health   *healthLoop
+	sweep    *interval
+	snapshot *interval
+}
+
+// Reload 重新读取配置文件与环境变量并应用到各组件，返回变化的参数。
+// 先完整校验并构造好所有组件参数，任一步失败都不做任何修改（保持当前配置）；
+// 需要重启才能生效的参数只记录日志，沿用旧值。已建立的隧道与在途请求不受影响
+func (r *reloader) Reload() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := config.Load(r.args)
+	var changes []config.Change
+	if err == nil {
+		changes = config.Diff(r.cfg, cfg)
+		cfg.KeepStatic(r.cfg)
+	}
+	var pt parts
+	if err == nil {
+		pt, err = build(cfg)
+	}
+	if err != nil {
+		metrics.ConfigReloads.WithLabelValues("rejected").Inc()
+		log.Printf("[RELOAD] rejected, keeping current configuration:\n%v", err)
+		return nil, err
+	}
+
+	if cfg.Strategy == r.cfg.Strategy {
+		pt.pool.Selector = nil // 保留选择器的状态（如轮询位置）
+	}
+	r.pool.SetOptions(pt.pool)
+	r.srv.Update(pt.server)
+	r.app.Update(pt.sources, pt.appender)
+	r.health.set(pt.health, cfg.HealthInterval)
+	r.sweep.set(cfg.SweepInterval)
+	r.snapshot.set(cfg.SnapshotInterval)
+	r.cfg = cfg
+	metrics.ConfigReloads.WithLabelValues("ok").Inc()
+
+	out := make([]string, 0, len(changes))
+	for _, c := range changes {
+		log.Printf("[RELOAD] %s", c)
+		out = append(out, c.String())
+	}
+	log.Printf("[RELOAD] applied changes=%d sources=%d auth-users=%d", len(changes), len(pt.sources), pt.server.Users.Len())
+	return out, nil
+}
+
+// healthLoop 后台检测；热加载时停止旧的检测循环，按新的参数重新开始
+type healthLoop struct {
+	ctx    context.Context
+	pool   *pool.Pool
+	cancel context.CancelFunc
+}
+
+// set pr 为 nil 时只停止
+func (h *healthLoop) set(pr *probe.Prober, every time.Duration) {
+	if h.cancel != nil {
+		h.cancel()
+		h.cancel = nil
+	}
+	if pr == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(h.ctx)
+	h.cancel = cancel
+	log.Printf("[HEALTH] check every %s via %s", every, pr.URL())
+	go probe.NewChecker(pr, h.pool, every).Run(ctx)
+}
+
+// interval 可热更新的循环间隔
+type interval struct {
+	d       atomic.Int64
+	changed chan struct{} // 容量 1：set 改变间隔后唤醒等待中的循环，按新间隔重新计时
+}
+
+func newInterval(d time.Duration) *interval {
+	iv := &interval{changed: make(chan struct{}, 1)}
+	iv.d.Store(int64(d))
+	return iv
+}
+
+func (iv *interval) set(d time.Duration) {
+	if iv.d.Swap(int64(d)) == int64(d) {
+		return
+	}
+	select {
+	case iv.changed <- struct{}{}:
+	default:
+	}
+}
+
+// every 每隔 iv 执行一次 fn，直到 ctx 取消；间隔 <=0 时暂停，直到被改为正数
+func every(ctx context.Context, iv *interval, fn func()) {
+	for {
+		var tick <-chan time.Time
+		var t *time.Timer
+		if d := time.Duration(iv.d.Load()); d > 0 {
+			t = time.NewTimer(d)
+			tick = t.C
+		}
+		select {
+		case <-tick:
+			fn()
+		case <-iv.changed:
+		case <-ctx.Done():
+		}
+		if t != nil {
+			t.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
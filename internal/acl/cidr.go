@@ -0,0 +1,109 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// CIDRList 一组网段（IPv4/IPv6）
+type CIDRList []netip.Prefix
+
+// ParseCIDRs 解析网段列表；每项可为 CIDR 或单个 IP，也可用逗号分隔多项
+func ParseCIDRs(items []string) (CIDRList, error) {
+	var out CIDRList
+	for _, item := range items {
+		for _, s := range strings.Split(item, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			pfx, err := parsePrefix(s)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, pfx)
+		}
+	}
+	return out, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		pfx, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("bad cidr %q: %w", s, err)
+		}
+		return pfx.Masked(), nil
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("bad ip %q: %w", s, err)
+	}
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// Contains 判断 ip 是否落在任一网段内（IPv4-mapped IPv6 按 IPv4 处理）
+func (l CIDRList) Contains(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, pfx := range l {
+		if pfx.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceACL 入站来源地址访问控制：先匹配 Deny，再匹配 Allow；Allow 为空表示放行其余全部
+type SourceACL struct {
+	Allow CIDRList
+	Deny  CIDRList
+}
+
+// NewSourceACL 解析 allow/deny 列表；两者都为空时返回 nil（表示不做限制）
+func NewSourceACL(allow, deny []string) (*SourceACL, error) {
+	a, err := ParseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	d, err := ParseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+	if len(a) == 0 && len(d) == 0 {
+		return nil, nil
+	}
+	return &SourceACL{Allow: a, Deny: d}, nil
+}
+
+// Check 返回是否放行；不放行时 reason 为 "deny" 或 "not-allowed"。nil ACL 放行全部。
+func (a *SourceACL) Check(addr net.Addr) (ok bool, reason string) {
+	if a == nil {
+		return true, ""
+	}
+	ip, parsed := addrIP(addr)
+	if !parsed {
+		return false, "unknown-addr"
+	}
+	if a.Deny.Contains(ip) {
+		return false, "deny"
+	}
+	if len(a.Allow) > 0 && !a.Allow.Contains(ip) {
+		return false, "not-allowed"
+	}
+	return true, ""
+}
+
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		ip, ok := netip.AddrFromSlice(tcp.IP)
+		return ip.Unmap(), ok
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ap.Addr().Unmap(), true
+}
@@ -0,0 +1,231 @@
+package acl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Action 目标规则命中后的处理方式
+type Action int
+
+const (
+	ActionNone   Action = iota // 未命中：按常规走池子（失败后是否直连由 --allow-direct 决定）
+	ActionBlock                // 拒绝请求
+	ActionDirect               // 绕过池子直连（如内部 API）
+	ActionPool                 // 必须经由池子，任何情况下都不直连
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionBlock:
+		return "block"
+	case ActionDirect:
+		return "direct"
+	case ActionPool:
+		return "pool"
+	}
+	return "none"
+}
+
+// privateCIDRs "cidr:private" 的展开：RFC1918、回环、链路本地、CGNAT 与 IPv6 ULA
+var privateCIDRs = []string{
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16",
+	"127.0.0.0/8", "169.254.0.0/16", "100.64.0.0/10", "0.0.0.0/8",
+	"::1/128", "fc00::/7", "fe80::/10",
+}
+
+// Rule 一条目标规则，格式为 "<action> <matcher>"：
+//
+//	host:example.com      精确主机名（也可直接写 example.com）
+//	*.example.com         仅匹配子域名
+//	suffix:example.com    匹配 example.com 及其子域名
+//	regex:^api\d+\.       正则匹配主机名
+//	cidr:10.0.0.0/8       目标 IP 落在网段内（主机名会先解析）；cidr:private 为内网网段集合
+//	port:25 / port:8000-9000
+type Rule struct {
+	Action Action
+	raw    string
+	needIP bool
+	match  func(host string, ips []netip.Addr, port int) bool
+}
+
+func (r *Rule) String() string { return r.raw }
+
+// Rules 按顺序求值，首条命中者生效
+type Rules struct {
+	list       []*Rule
+	needIP     bool
+	failClosed bool // 主机名无法解析时 block 的 cidr 规则视为命中
+	resolver   *net.Resolver
+	timeout    time.Duration
+}
+
+// ParseRules 解析规则列表；为空时返回 nil（不做任何限制）。
+// failClosed 为 true 时，本地无法解析的主机名命中 block 的 cidr 规则（拒绝而不是放行）
+func ParseRules(specs []string, resolveTimeout time.Duration, failClosed bool) (*Rules, error) {
+	rs := &Rules{resolver: net.DefaultResolver, timeout: resolveTimeout, failClosed: failClosed}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" || strings.HasPrefix(spec, "#") {
+			continue
+		}
+		r, err := parseRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rs.list = append(rs.list, r)
+		rs.needIP = rs.needIP || r.needIP
+	}
+	if len(rs.list) == 0 {
+		return nil, nil
+	}
+	return rs, nil
+}
+
+func parseRule(spec string) (*Rule, error) {
+	action, m, _ := strings.Cut(spec, " ")
+	m = strings.TrimSpace(m)
+	if m == "" {
+		return nil, fmt.Errorf("bad rule %q, want \"<block|direct|pool> <matcher>\"", spec)
+	}
+	r := &Rule{raw: spec}
+	switch strings.ToLower(action) {
+	case "block":
+		r.Action = ActionBlock
+	case "direct":
+		r.Action = ActionDirect
+	case "pool":
+		r.Action = ActionPool
+	default:
+		return nil, fmt.Errorf("bad rule %q: unknown action %q", spec, action)
+	}
+
+	kind, val, _ := strings.Cut(m, ":")
+	switch strings.ToLower(kind) {
+	case "host", "suffix", "regex", "cidr", "port":
+	default:
+		// 无前缀（或为 IPv6 字面量）：按形态推断
+		kind, val = "host", m
+		if strings.HasPrefix(m, "*.") {
+			kind = "wildcard"
+		} else if _, err := parsePrefix(m); err == nil {
+			kind = "cidr"
+		}
+	}
+	switch strings.ToLower(kind) {
+	case "host":
+		want := strings.ToLower(strings.TrimSuffix(val, "."))
+		r.match = func(host string, _ []netip.Addr, _ int) bool { return host == want }
+	case "wildcard":
+		suffix := strings.ToLower(strings.TrimPrefix(val, "*"))
+		r.match = func(host string, _ []netip.Addr, _ int) bool { return strings.HasSuffix(host, suffix) }
+	case "suffix":
+		apex := strings.ToLower(strings.TrimPrefix(val, "."))
+		r.match = func(host string, _ []netip.Addr, _ int) bool {
+			return host == apex || strings.HasSuffix(host, "."+apex)
+		}
+	case "regex":
+		re, err := regexp.Compile(val)
+		if err != nil {
+			return nil, fmt.Errorf("bad rule %q: %w", spec, err)
+		}
+		r.match = func(host string, _ []netip.Addr, _ int) bool { return re.MatchString(host) }
+	case "cidr":
+		items := []string{val}
+		if strings.EqualFold(val, "private") {
+			items = privateCIDRs
+		}
+		list, err := ParseCIDRs(items)
+		if err != nil {
+			return nil, fmt.Errorf("bad rule %q: %w", spec, err)
+		}
+		r.needIP = true
+		r.match = func(_ string, ips []netip.Addr, _ int) bool {
+			for _, ip := range ips {
+				if list.Contains(ip) {
+					return true
+				}
+			}
+			return false
+		}
+	case "port":
+		lo, hi, err := parsePortRange(val)
+		if err != nil {
+			return nil, fmt.Errorf("bad rule %q: %w", spec, err)
+		}
+		r.match = func(_ string, _ []netip.Addr, port int) bool { return port >= lo && port <= hi }
+	default:
+		return nil, fmt.Errorf("bad rule %q: unknown matcher %q", spec, kind)
+	}
+	return r, nil
+}
+
+func parsePortRange(s string) (lo, hi int, err error) {
+	a, b, isRange := strings.Cut(s, "-")
+	if lo, err = strconv.Atoi(a); err != nil {
+		return 0, 0, fmt.Errorf("bad port %q", s)
+	}
+	hi = lo
+	if isRange {
+		if hi, err = strconv.Atoi(b); err != nil || hi < lo {
+			return 0, 0, fmt.Errorf("bad port range %q", s)
+		}
+	}
+	return lo, hi, nil
+}
+
+// Match 对目标 host:port 求值，返回首条命中规则的动作；nil Rules 或未命中返回 ActionNone。
+// 存在 cidr 规则且 host 为域名时会先在本地做一次 DNS 解析；解析失败时 cidr 规则视为不命中，
+// 开启 failClosed 时 block 的 cidr 规则视为命中。上游会自行解析域名，结果可能与本地不同，
+// 因此 cidr 规则对域名只是尽力而为，只有 IP 字面量的判定是确定的。
+func (rs *Rules) Match(host string, port int) (Action, *Rule) {
+	if rs == nil {
+		return ActionNone, nil
+	}
+	host = strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+
+	var ips []netip.Addr
+	unresolved := false
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ips = []netip.Addr{ip.Unmap()}
+	} else if rs.needIP {
+		ips = rs.resolve(host)
+		unresolved = len(ips) == 0
+	}
+	for _, r := range rs.list {
+		if r.match(host, ips, port) {
+			return r.Action, r
+		}
+		if unresolved && rs.failClosed && r.needIP && r.Action == ActionBlock {
+			return r.Action, r
+		}
+	}
+	return ActionNone, nil
+}
+
+func (rs *Rules) resolve(host string) []netip.Addr {
+	ctx, cancel := context.WithTimeout(context.Background(), rs.timeout)
+	defer cancel()
+	addrs, err := rs.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs
+}
+
+// Len 规则条数
+func (rs *Rules) Len() int {
+	if rs == nil {
+		return 0
+	}
+	return len(rs.list)
+}
@@ -0,0 +1,148 @@
+package acl
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		action  Action
+		wantErr string
+	}{
+		{"block host:example.com", ActionBlock, ""},
+		{"DIRECT example.com", ActionDirect, ""},
+		{"pool *.example.com", ActionPool, ""},
+		{"block suffix:example.com", ActionBlock, ""},
+		{`block regex:^api\d+\.`, ActionBlock, ""},
+		{"block cidr:10.0.0.0/8", ActionBlock, ""},
+		{"block cidr:private", ActionBlock, ""},
+		{"block 192.168.1.1", ActionBlock, ""},
+		{"block ::1", ActionBlock, ""},
+		{"block port:25", ActionBlock, ""},
+		{"block port:8000-9000", ActionBlock, ""},
+		{"block", 0, "want"},
+		{"allow example.com", 0, "unknown action"},
+		{"block regex:(", 0, "missing closing"},
+		{"block cidr:10.0.0.0/33", 0, "bad rule"},
+		{"block port:x", 0, "bad port"},
+		{"block port:9000-8000", 0, "bad port range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			r, err := parseRule(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseRule error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Action != tt.action || r.String() != tt.spec {
+				t.Fatalf("parseRule = %s %q, want %s", r.Action, r, tt.action)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	rs, err := ParseRules([]string{
+		"# 注释与空行被忽略",
+		"",
+		"direct host:api.example.com",
+		"pool *.corp.example",
+		"block suffix:ads.example",
+		`direct regex:^cdn[0-9]+\.example\.net$`,
+		"block cidr:private",
+		"pool 2001:db8::/32",
+		"block port:25",
+		"direct port:8000-9000",
+	}, time.Second, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host string
+		port int
+		want Action
+		rule string
+	}{
+		{"api.example.com", 443, ActionDirect, "direct host:api.example.com"},
+		{"API.Example.com.", 443, ActionDirect, "direct host:api.example.com"},
+		{"x.api.example.com", 443, ActionNone, ""},
+		{"a.corp.example", 443, ActionPool, "pool *.corp.example"},
+		{"a.b.corp.example", 443, ActionPool, "pool *.corp.example"},
+		{"corp.example", 443, ActionNone, ""},
+		{"ads.example", 80, ActionBlock, "block suffix:ads.example"},
+		{"x.ads.example", 80, ActionBlock, "block suffix:ads.example"},
+		{"badads.example", 80, ActionNone, ""},
+		{"cdn12.example.net", 443, ActionDirect, `direct regex:^cdn[0-9]+\.example\.net$`},
+		{"cdn.example.net", 443, ActionNone, ""},
+		{"10.1.2.3", 443, ActionBlock, "block cidr:private"},
+		{"192.168.0.1", 443, ActionBlock, "block cidr:private"},
+		{"100.64.0.1", 443, ActionBlock, "block cidr:private"},
+		{"8.8.8.8", 443, ActionNone, ""},
+		{"::1", 443, ActionBlock, "block cidr:private"},
+		{"[fd00::1]", 443, ActionBlock, "block cidr:private"},
+		{"fe80::1", 443, ActionBlock, "block cidr:private"},
+		{"::ffff:10.0.0.1", 443, ActionBlock, "block cidr:private"},
+		{"[2001:db8::1]", 443, ActionPool, "pool 2001:db8::/32"},
+		{"2001:4860::1", 443, ActionNone, ""},
+		{"8.8.8.8", 25, ActionBlock, "block port:25"},
+		{"8.8.8.8", 8000, ActionDirect, "direct port:8000-9000"},
+		{"8.8.8.8", 9000, ActionDirect, "direct port:8000-9000"},
+		{"8.8.8.8", 9001, ActionNone, ""},
+	}
+	for _, tt := range tests {
+		action, r := rs.Match(tt.host, tt.port)
+		rule := ""
+		if r != nil {
+			rule = r.String()
+		}
+		if action != tt.want || rule != tt.rule {
+			t.Errorf("Match(%q, %d) = %s %q, want %s %q", tt.host, tt.port, action, rule, tt.want, tt.rule)
+		}
+	}
+
+	var nilRules *Rules
+	if action, r := nilRules.Match("10.0.0.1", 80); action != ActionNone || r != nil {
+		t.Errorf("nil Rules matched %s", action)
+	}
+}
+
+// 主机名无法解析时：默认 cidr 规则不命中（放行），failClosed 时 block 的 cidr 规则命中
+func TestMatchUnresolved(t *testing.T) {
+	failing := &net.Resolver{
+		PreferGo: true,
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			return nil, errors.New("dns unavailable")
+		},
+	}
+	specs := []string{"pool cidr:8.8.8.0/24", "block cidr:private"}
+	for _, failClosed := range []bool{false, true} {
+		rs, err := ParseRules(specs, time.Second, failClosed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.resolver = failing
+		action, r := rs.Match("internal.invalid", 443)
+		want := ActionNone
+		if failClosed {
+			want = ActionBlock
+		}
+		if action != want {
+			t.Errorf("failClosed=%v: Match = %s (%v), want %s", failClosed, action, r, want)
+		}
+		// IP 字面量不受解析影响
+		if action, _ := rs.Match("8.8.8.8", 443); action != ActionPool {
+			t.Errorf("failClosed=%v: Match(8.8.8.8) = %s, want pool", failClosed, action)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/appender"
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+	"github.com/lianshufeng/proxy-pool/internal/upstream"
+)
+
+// Source 手动添加的代理记录的来源名称
+const Source = "admin"
+
+// Options 管理 API 参数
+type Options struct {
+	Listen   string
+	Token    string // 请求需携带 Authorization: Bearer <token> 或 X-Admin-Token
+	Pool     *pool.Pool
+	Appender *appender.Appender
+	TTL      time.Duration // 手动添加且未指定 ttl 时的生存时长
+
+	// Reload 重新读取并应用配置，返回变化的参数；校验失败时返回错误且保持当前配置。nil 表示不支持
+	Reload func() ([]string, error)
+}
+
+// Server 独立监听的管理 API：查看池子、手动增删/封禁上游、清空、立即拉取、暂停/恢复追加、重新加载配置
+type Server struct {
+	opts    Options
+	httpSrv *http.Server
+}
+
+// New 创建管理 API；Token 不能为空
+func New(opts Options) (*Server, error) {
+	if opts.Token == "" {
+		return nil, errors.New("admin api needs a token")
+	}
+	s := &Server{opts: opts}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.status)
+	mux.HandleFunc("GET /proxies", s.listProxies)
+	mux.HandleFunc("POST /proxies", s.addProxy)
+	mux.HandleFunc("DELETE /proxies", s.removeProxy)
+	mux.HandleFunc("GET /bans", s.listBans)
+	mux.HandleFunc("POST /bans", s.ban)
+	mux.HandleFunc("DELETE /bans", s.unban)
+	mux.HandleFunc("POST /drain", s.drain)
+	mux.HandleFunc("POST /sweep", s.sweep)
+	mux.HandleFunc("POST /fetch", s.fetch)
+	mux.HandleFunc("POST /pause", s.pause)
+	mux.HandleFunc("POST /resume", s.resume)
+	mux.HandleFunc("POST /reload", s.reload)
+	s.httpSrv = &http.Server{
+		Addr:              opts.Listen,
+		Handler:           s.authMiddleware(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s, nil
+}
+
+// Start 监听并阻塞处理请求，直到 Shutdown
+func (s *Server) Start() error {
+	log.Printf("[ADMIN] listening on %s", s.opts.Listen)
+	return s.httpSrv.ListenAndServe()
+}
+
+func (s *Server) Shutdown() error {
+	return s.httpSrv.Close()
+}
+
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Admin-Token")
+		if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			token = strings.TrimSpace(v)
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) != 1 {
+			log.Printf("[ADMIN] reject %s %s From=%s", r.Method, r.URL.Path, r.RemoteAddr)
+			writeError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// proxyView 单个代理的 JSON 表示
+type proxyView struct {
+	Addr             string            `json:"addr"`
+	Source           string            `json:"source,omitempty"`
+	Tag              string            `json:"tag,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	Weight           int               `json:"weight,omitempty"`
+	ExpireAt         time.Time         `json:"expire_at"`
+	ExpiresIn        string            `json:"expires_in"`
+	VendorExpireAt   *time.Time        `json:"vendor_expire_at,omitempty"`
+	ExitIP           string            `json:"exit_ip,omitempty"`
+	Anonymity        string            `json:"anonymity"`
+	Success          uint64            `json:"success"`
+	Failure          uint64            `json:"failure"`
+	ConsecutiveFails int               `json:"consecutive_fails"`
+	QuarantineUntil  *time.Time        `json:"quarantine_until,omitempty"`
+	InFlight         int64             `json:"in_flight"`
+	LatencyMs        float64           `json:"latency_ms"`
+}
+
+func newProxyView(in pool.Info, now time.Time) proxyView {
+	v := proxyView{
+		Addr:             in.Addr,
+		Source:           in.Source,
+		Tag:              in.Tag,
+		Labels:           in.Labels,
+		Weight:           in.Weight,
+		ExpireAt:         in.ExpireAt,
+		ExpiresIn:        in.ExpireAt.Sub(now).Round(time.Second).String(),
+		ExitIP:           in.ExitIP,
+		Anonymity:        in.Anonymity.String(),
+		Success:          in.Success,
+		Failure:          in.Failure,
+		ConsecutiveFails: in.ConsecutiveFails,
+		InFlight:         in.InFlight,
+		LatencyMs:        float64(in.LatencyEWMA) / float64(time.Millisecond),
+	}
+	if !in.VendorExpireAt.IsZero() {
+		v.VendorExpireAt = &in.VendorExpireAt
+	}
+	if now.Before(in.QuarantineUntil) {
+		v.QuarantineUntil = &in.QuarantineUntil
+	}
+	return v
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"size":   s.opts.Pool.Size(),
+		"usable": s.opts.Pool.Usable(),
+		"bans":   len(s.opts.Pool.Bans()),
+		"paused": s.opts.Appender.Paused(),
+	})
+}
+
+func (s *Server) listProxies(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	list := s.opts.Pool.List()
+	out := make([]proxyView, 0, len(list))
+	for _, in := range list {
+		out = append(out, newProxyView(in, now))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// addRequest POST /proxies 与 POST /bans 的请求体
+type addRequest struct {
+	Addr     string            `json:"addr"`
+	TTL      string            `json:"ttl"`      // POST /proxies：生存时长，如 "10m"；空取 --ttl
+	Duration string            `json:"duration"` // POST /bans：封禁时长；空为永久
+	Weight   int               `json:"weight"`   // POST /proxies：weighted 策略下的代理权重；0 视为 1
+	Labels   map[string]string `json:"labels"`
+}
+
+func (s *Server) addProxy(w http.ResponseWriter, r *http.Request) {
+	var req addRequest
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := upstream.Parse(req.Addr); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ttl, err := parseDuration(req.TTL, s.opts.TTL)
+	if err != nil || ttl <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ttl %q", req.TTL))
+		return
+	}
+	res := s.opts.Pool.AddFrom(req.Addr, ttl, pool.Origin{Source: Source, Weight: req.Weight, Labels: req.Labels})
+	log.Printf("[ADMIN] add %q ttl=%s -> %s size=%d", req.Addr, ttl, res, s.opts.Pool.Size())
+	status := http.StatusOK
+	switch res {
+	case pool.Added:
+		status = http.StatusCreated
+	case pool.Renewed:
+	default:
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, map[string]any{"addr": req.Addr, "result": res.String()})
+}
+
+func (s *Server) removeProxy(w http.ResponseWriter, r *http.Request) {
+	addr := r.URL.Query().Get("addr")
+	if addr == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing addr"))
+		return
+	}
+	if !s.opts.Pool.Remove(addr) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%q not in pool", addr))
+		return
+	}
+	log.Printf("[ADMIN] remove %q size=%d", addr, s.opts.Pool.Size())
+	writeJSON(w, http.StatusOK, map[string]any{"addr": addr, "removed": true})
+}
+
+// banView 单条封禁的 JSON 表示；until 为空表示永久
+type banView struct {
+	Addr  string     `json:"addr"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+func (s *Server) listBans(w http.ResponseWriter, r *http.Request) {
+	bans := s.opts.Pool.Bans()
+	out := make([]banView, 0, len(bans))
+	for _, b := range bans {
+		v := banView{Addr: b.Addr}
+		if !b.Until.IsZero() {
+			v.Until = &b.Until
+		}
+		out = append(out, v)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) ban(w http.ResponseWriter, r *http.Request) {
+	var req addRequest
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Addr == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing addr"))
+		return
+	}
+	d, err := parseDuration(req.Duration, 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration %q", req.Duration))
+		return
+	}
+	removed := s.opts.Pool.Ban(req.Addr, d)
+	log.Printf("[ADMIN] ban %q duration=%s removed=%v", req.Addr, d, removed)
+	writeJSON(w, http.StatusOK, map[string]any{"addr": req.Addr, "removed": removed})
+}
+
+func (s *Server) unban(w http.ResponseWriter, r *http.Request) {
+	addr := r.URL.Query().Get("addr")
+	if addr == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing addr"))
+		return
+	}
+	if !s.opts.Pool.Unban(addr) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%q not banned", addr))
+		return
+	}
+	log.Printf("[ADMIN] unban %q", addr)
+	writeJSON(w, http.StatusOK, map[string]any{"addr": addr, "unbanned": true})
+}
+
+func (s *Server) drain(w http.ResponseWriter, r *http.Request) {
+	n := s.opts.Pool.Drain()
+	log.Printf("[ADMIN] drain removed=%d", n)
+	writeJSON(w, http.StatusOK, map[string]any{"removed": n})
+}
+
+func (s *Server) sweep(w http.ResponseWriter, r *http.Request) {
+	before := s.opts.Pool.Size()
+	s.opts.Pool.Sweep()
+	after := s.opts.Pool.Size()
+	log.Printf("[ADMIN] sweep before=%d after=%d", before, after)
+	writeJSON(w, http.StatusOK, map[string]any{"removed": before - after, "size": after})
+}
+
+// fetch 同步地从每个来源各追加一批，暂停时也执行
+func (s *Server) fetch(w http.ResponseWriter, r *http.Request) {
+	n := s.opts.Appender.Fetch(r.Context())
+	log.Printf("[ADMIN] fetch added=%d size=%d", n, s.opts.Pool.Size())
+	writeJSON(w, http.StatusOK, map[string]any{"added": n, "size": s.opts.Pool.Size()})
+}
+
+func (s *Server) pause(w http.ResponseWriter, r *http.Request) {
+	s.opts.Appender.Pause()
+	writeJSON(w, http.StatusOK, map[string]any{"paused": true})
+}
+
+func (s *Server) resume(w http.ResponseWriter, r *http.Request) {
+	s.opts.Appender.Resume()
+	writeJSON(w, http.StatusOK, map[string]any{"paused": false})
+}
+
+// reload 与 SIGHUP 相同：重新读取配置文件与环境变量并应用；校验失败返回 422，当前配置不变
+func (s *Server) reload(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Reload == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("reload not supported"))
+		return
+	}
+	changes, err := s.opts.Reload()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if changes == nil {
+		changes = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"changes": changes})
+}
+
+// decode 解析 JSON 请求体；请求体为空时 addr 可由查询参数给出
+func decode(r *http.Request, v *addRequest) error {
+	if r.ContentLength != 0 {
+		dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 64<<10))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v); err != nil {
+			return fmt.Errorf("invalid json body: %w", err)
+		}
+	}
+	if v.Addr == "" {
+		v.Addr = r.URL.Query().Get("addr")
+	}
+	v.Addr = strings.TrimSpace(v.Addr)
+	return nil
+}
+
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,428 @@
+package appender
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/fetcher"
+	"github.com/lianshufeng/proxy-pool/internal/metrics"
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+	"github.com/lianshufeng/proxy-pool/internal/probe"
+)
+
+// controlInterval 低水位检查的兜底周期；隔离、临近过期这类不触发 Removed 的缩减靠它发现
+const controlInterval = time.Second
+
+// Options 池子规模控制与入池检测
+type Options struct {
+	MinSize   int // 可用代理低于该值时立即批量补充；<=0 关闭
+	MaxSize   int // 池子达到该值时暂停拉取；<=0 不限
+	BatchSize int // 每次追加的条目数；<=0 视为 1
+
+	Prober    *probe.Prober     // 入池前检测；nil 表示不检测直接入池
+	ExitIP    *probe.Prober     // 入池前经候选请求 IP 回显地址，记录出口 IP；nil 表示不探测
+	Anonymity *probe.Classifier // 入池前判定匿名等级；nil 表示不判定
+}
+
+// Appender 按各来源自己的间隔从 API 取代理，合并进同一个池子（按地址去重），
+// 并把池子规模维持在 [MinSize, MaxSize] 之间
+type Appender struct {
+	pool        *pool.Pool
+	dialTimeout time.Duration
+	full        atomic.Bool // 上一次检查时池子已满，用于只在状态切换时打日志
+	paused      atomic.Bool // 暂停定时追加与低水位补充；Fetch 不受影响
+
+	mu      sync.Mutex // 保护 opts、sources 与 ctx，Update 时整体替换
+	opts    Options
+	sources []*source
+	ctx     context.Context // Run 的 ctx；Run 之前为 nil
+}
+
+type source struct {
+	mu   sync.Mutex // Fetcher 的缓存游标不是并发安全的：定时循环与补充可能同时取条目
+	ft   *fetcher.Fetcher
+	now  chan struct{}      // 立即追加一次的信号（容量 1）
+	stop context.CancelFunc // 结束该来源的追加循环；未启动时为 nil
+}
+
+// New 为每个来源创建一个 Fetcher；dialTimeout 为拉取 API 的超时
+func New(p *pool.Pool, srcs []fetcher.Source, dialTimeout time.Duration, opts Options) *Appender {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	a := &Appender{pool: p, dialTimeout: dialTimeout, opts: opts}
+	for _, src := range srcs {
+		a.sources = append(a.sources, a.newSource(src))
+	}
+	return a
+}
+
+func (a *Appender) newSource(src fetcher.Source) *source {
+	return &source{
+		ft:  fetcher.NewSource(src, a.dialTimeout),
+		now: make(chan struct{}, 1),
+	}
+}
+
+// Run 为每个来源启动一个追加循环，并启动低水位补充（MinSize<=0 时空转）；ctx 取消后退出，不阻塞
+func (a *Appender) Run(ctx context.Context) {
+	a.mu.Lock()
+	a.ctx = ctx
+	for _, s := range a.sources {
+		a.startLocked(s)
+	}
+	a.mu.Unlock()
+	go a.control(ctx)
+}
+
+// startLocked 启动来源 s 的追加循环；调用方需持锁且已 Run
+func (a *Appender) startLocked(s *source) {
+	ctx, cancel := context.WithCancel(a.ctx)
+	s.stop = cancel
+	go a.loop(ctx, s)
+}
+
+// Update 热更新来源与规模/检测参数：配置未变的来源保留原有的循环与缓存游标，
+// 新增或配置变化（如间隔）的来源重新开始，已移除的来源停止追加。已在池中的代理不受影响
+func (a *Appender) Update(srcs []fetcher.Source, opts Options) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	old := make(map[string]*source, len(a.sources))
+	for _, s := range a.sources {
+		old[s.ft.Source().Name] = s
+	}
+	next := make([]*source, 0, len(srcs))
+	for _, src := range srcs {
+		if s, ok := old[src.Name]; ok && reflect.DeepEqual(s.ft.Source(), src) {
+			delete(old, src.Name)
+			next = append(next, s)
+			continue
+		}
+		s := a.newSource(src)
+		if a.ctx != nil {
+			a.startLocked(s)
+		}
+		if _, ok := old[src.Name]; ok {
+			log.Printf("[APPEND] source=%s updated interval=%s ttl=%s weight=%d", src.Name, src.Interval, src.TTL, src.Weight)
+		} else {
+			log.Printf("[APPEND] source=%s added interval=%s ttl=%s weight=%d", src.Name, src.Interval, src.TTL, src.Weight)
+		}
+		next = append(next, s)
+	}
+	for name, s := range old {
+		if s.stop != nil {
+			s.stop()
+		}
+		if !containsName(srcs, name) {
+			log.Printf("[APPEND] source=%s removed", name)
+		}
+	}
+	a.sources = next
+	a.opts = opts
+}
+
+func containsName(srcs []fetcher.Source, name string) bool {
+	for _, src := range srcs {
+		if src.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// options 当前的规模与检测参数
+func (a *Appender) options() Options {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.opts
+}
+
+// list 当前的来源
+func (a *Appender) list() []*source {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sources
+}
+
+// FetchNow 通知所有来源立即追加一次；非阻塞，已有待处理的通知时合并
+func (a *Appender) FetchNow() {
+	for _, s := range a.list() {
+		select {
+		case s.now <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Fetch 立即从每个来源各追加一批，暂停时也执行；返回新入池的个数
+func (a *Appender) Fetch(ctx context.Context) int {
+	n := 0
+	for _, s := range a.list() {
+		n += a.fill(ctx, s)
+	}
+	return n
+}
+
+// Pause 暂停定时追加与低水位补充（例如供应商额度将尽时）；已在进行的一批不受影响
+func (a *Appender) Pause() {
+	if !a.paused.Swap(true) {
+		log.Printf("[APPEND] paused")
+	}
+}
+
+// Resume 恢复定时追加与低水位补充
+func (a *Appender) Resume() {
+	if a.paused.Swap(false) {
+		log.Printf("[APPEND] resumed")
+	}
+}
+
+// Paused 是否处于暂停中
+func (a *Appender) Paused() bool {
+	return a.paused.Load()
+}
+
+// loop 每隔来源的 Interval 追加一批（BatchSize 个）代理；收到 FetchNow 时立即追加
+func (a *Appender) loop(ctx context.Context, s *source) {
+	src := s.ft.Source()
+	tk := time.NewTicker(src.Interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			if !a.paused.Load() {
+				a.fill(ctx, s)
+			}
+		case <-s.now:
+			if a.paused.Load() {
+				continue
+			}
+			log.Printf("[APPEND] source=%s pool empty -> fetch now", src.Name)
+			a.fill(ctx, s)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// control 可用代理低于 MinSize 时立即补充，不等各来源的定时器
+func (a *Appender) control(ctx context.Context) {
+	tk := time.NewTicker(controlInterval)
+	defer tk.Stop()
+	var (
+		backoff time.Duration // 上一轮补充没有进展后的等待时长；0 表示不退避
+		retryAt time.Time     // 退避期间不再补充，避免每秒都调用供应商 API
+	)
+	for {
+		removed := a.pool.Removed()
+		if minSize := a.options().MinSize; minSize > 0 && !a.paused.Load() {
+			switch n := a.pool.Usable(); {
+			case n >= minSize:
+				backoff, retryAt = 0, time.Time{}
+			case time.Now().Before(retryAt):
+			default:
+				log.Printf("[APPEND] usable=%d below min-size=%d -> refill", n, minSize)
+				if a.refill(ctx, minSize) {
+					backoff, retryAt = 0, time.Time{}
+					break
+				}
+				backoff = a.nextBackoff(backoff)
+				retryAt = time.Now().Add(backoff)
+				log.Printf("[APPEND] refill made no progress (usable=%d) -> back off %s", a.pool.Usable(), backoff)
+			}
+		}
+		select {
+		case <-removed:
+		case <-tk.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refill 轮流从各来源取一批，直到达到 minSize、池子已满或一整轮没有新代理入池（避免空耗 API 额度）；
+// 一整轮没有进展时返回 false，由 control 退避
+func (a *Appender) refill(ctx context.Context, minSize int) bool {
+	for a.pool.Usable() < minSize && ctx.Err() == nil && !a.paused.Load() {
+		progress := false
+		for _, s := range a.list() {
+			if a.fill(ctx, s) > 0 {
+				progress = true
+			}
+			if a.pool.Usable() >= minSize {
+				return true
+			}
+		}
+		if !progress {
+			return false
+		}
+	}
+	return true
+}
+
+// nextBackoff 补充无进展后的下一次等待：从 2*controlInterval 起逐次翻倍，
+// 不超过各来源中最短的 Interval（届时来源自己的定时追加也会再取一次）
+func (a *Appender) nextBackoff(d time.Duration) time.Duration {
+	d = max(2*d, 2*controlInterval)
+	for _, s := range a.list() {
+		if iv := s.ft.Source().Interval; iv > 0 && d > iv {
+			d = iv
+		}
+	}
+	return d
+}
+
+// fill 从来源 s 取至多 BatchSize 个条目（不超过 MaxSize 的剩余容量），
+// 新地址经检测并发验证后入池；返回新入池的个数
+func (a *Appender) fill(ctx context.Context, s *source) int {
+	src := s.ft.Source()
+	opts := a.options()
+	if a.isFull(opts.MaxSize) {
+		return 0
+	}
+	n := opts.BatchSize
+	if opts.MaxSize > 0 {
+		n = min(n, opts.MaxSize-a.pool.Size())
+	}
+
+	s.mu.Lock()
+	entries := make([]fetcher.Entry, 0, n)
+	for i := 0; i < n; i++ {
+		e, err := s.ft.Next(ctx)
+		if err != nil {
+			log.Printf("[APPEND] source=%s fetch next failed: %v", src.Name, err)
+			break
+		}
+		entries = append(entries, e)
+	}
+	s.mu.Unlock()
+
+	var (
+		wg    sync.WaitGroup
+		added atomic.Int32
+	)
+	for _, e := range entries {
+		// 已在池中的只续期，不重复检测
+		if !opts.validating() || a.pool.Contains(e.Addr) {
+			if a.admit(src, e, verdict{}) {
+				added.Add(1)
+			}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := opts.validate(ctx, src, e)
+			if err != nil {
+				log.Printf("[PROBE] source=%s reject=%q: %v", src.Name, e.Addr, err)
+				metrics.ProxiesRejected.WithLabelValues(src.Name, "check-failed").Inc()
+				return
+			}
+			log.Printf("[PROBE] source=%s pass=%q latency=%s exit-ip=%s anonymity=%s", src.Name, e.Addr, v.latency, v.exitIP, v.anonymity)
+			if a.admit(src, e, v) {
+				added.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	return int(added.Load())
+}
+
+// verdict 入池检测的结果
+type verdict struct {
+	latency   time.Duration // 首个延迟样本；0 表示没有
+	exitIP    string
+	anonymity pool.Anonymity
+}
+
+// validating 是否开启了任一入池检测
+func (o Options) validating() bool {
+	return o.Prober != nil || o.ExitIP != nil || o.Anonymity != nil
+}
+
+// validate 入池检测：先按 Prober 检测，再经 ExitIP 取出口 IP，最后判定匿名等级；任一失败即拒绝
+func (o Options) validate(ctx context.Context, src fetcher.Source, e fetcher.Entry) (v verdict, err error) {
+	if o.Prober != nil {
+		res, err := o.Prober.Check(ctx, e.Addr, src.Name)
+		if err != nil {
+			return v, err
+		}
+		v.latency = res.Latency
+	}
+	if o.ExitIP != nil {
+		start := time.Now()
+		if v.exitIP, err = o.ExitIP.ExitIP(ctx, e.Addr, src.Name); err != nil {
+			return v, fmt.Errorf("exit ip: %w", err)
+		}
+		if v.latency == 0 {
+			v.latency = time.Since(start)
+		}
+	}
+	if o.Anonymity != nil {
+		start := time.Now()
+		if v.anonymity, err = o.Anonymity.Classify(ctx, e.Addr, src.Name); err != nil {
+			return v, fmt.Errorf("anonymity: %w", err)
+		}
+		if v.latency == 0 {
+			v.latency = time.Since(start)
+		}
+	}
+	return v, nil
+}
+
+// isFull 池子是否已达 maxSize；状态切换时打日志
+func (a *Appender) isFull(maxSize int) bool {
+	if maxSize <= 0 {
+		return false
+	}
+	size := a.pool.Size()
+	full := size >= maxSize
+	if a.full.Swap(full) != full {
+		if full {
+			log.Printf("[APPEND] pool full size=%d max-size=%d -> pause fetching", size, maxSize)
+		} else {
+			log.Printf("[APPEND] pool below max-size (size=%d) -> resume fetching", size)
+		}
+	}
+	return full
+}
+
+// admit 把条目加入池子；v.latency>0 时作为首个延迟样本。返回是否为新入池的代理
+func (a *Appender) admit(src fetcher.Source, e fetcher.Entry, v verdict) bool {
+	o := pool.Origin{Source: src.Name, Tag: src.Tag, SourceWeight: src.Weight, Weight: e.Weight, Labels: e.Labels, ExpireAt: e.ExpireAt, ExitIP: v.exitIP, Anonymity: v.anonymity}
+	switch r := a.pool.AddFrom(e.Addr, src.TTL, o); r {
+	case pool.Added:
+	case pool.Renewed:
+		log.Printf("[APPEND] source=%s renewed=%q size=%d", src.Name, e.Addr, a.pool.Size())
+		return false
+	case pool.Expiring:
+		log.Printf("[APPEND] source=%s skipped=%q: expires too soon (vendor-expire=%s)", src.Name, e.Addr, fmtExpire(e.ExpireAt))
+		metrics.ProxiesRejected.WithLabelValues(src.Name, r.String()).Inc()
+		return false
+	default:
+		log.Printf("[APPEND] source=%s skipped=%q: %s exit-ip=%s anonymity=%s", src.Name, e.Addr, r, v.exitIP, v.anonymity)
+		metrics.ProxiesRejected.WithLabelValues(src.Name, r.String()).Inc()
+		return false
+	}
+	if v.latency > 0 {
+		a.pool.ReportSuccess(e.Addr, v.latency)
+	}
+	log.Printf("[APPEND] source=%s added=%q labels=%v vendor-expire=%s exit-ip=%s anonymity=%s size=%d",
+		src.Name, e.Addr, e.Labels, fmtExpire(e.ExpireAt), v.exitIP, v.anonymity, a.pool.Size())
+	return true
+}
+
+func fmtExpire(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,23 @@
+package appender
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/fetcher"
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+)
+
+func TestNextBackoff(t *testing.T) {
+	a := New(pool.New(pool.Options{}), []fetcher.Source{
+		{Name: "a", Interval: 30 * time.Second},
+		{Name: "b", Interval: 10 * time.Second},
+	}, time.Second, Options{})
+	want := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	var d time.Duration
+	for i, w := range want {
+		if d = a.nextBackoff(d); d != w {
+			t.Fatalf("round %d: backoff = %s, want %s", i+1, d, w)
+		}
+	}
+}
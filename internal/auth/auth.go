@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Users 入站代理的用户表：用户名 -> 密码（bcrypt 哈希或明文）
+type Users struct {
+	m map[string]string
+
+	mu       sync.Mutex
+	verified map[[32]byte]struct{} // 已校验通过的凭据摘要，避免每个请求都跑一次 bcrypt
+}
+
+// Load 合并 htpasswd 风格文件（每行 user:hash，# 开头为注释）与内联的 "user:pass" 列表；
+// 内联项覆盖文件中的同名用户。两者都为空时返回 nil（表示不启用认证）。
+func Load(file string, inline []string) (*Users, error) {
+	u := &Users{m: make(map[string]string), verified: make(map[[32]byte]struct{})}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for n := 1; sc.Scan(); n++ {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err := u.add(line); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range inline {
+		if err := u.add(s); err != nil {
+			return nil, err
+		}
+	}
+	if len(u.m) == 0 {
+		return nil, nil
+	}
+	return u, nil
+}
+
+func (u *Users) add(entry string) error {
+	name, secret, ok := strings.Cut(entry, ":")
+	if !ok || name == "" || secret == "" {
+		return fmt.Errorf("invalid user entry %q, want user:password", entry)
+	}
+	if isBcrypt(secret) {
+		if _, err := bcrypt.Cost([]byte(secret)); err != nil {
+			return fmt.Errorf("user %q: bad bcrypt hash: %w", name, err)
+		}
+	}
+	u.m[name] = secret
+	return nil
+}
+
+// isBcrypt 识别 $2a$/$2b$/$2y$ 前缀的 bcrypt 哈希，其余按明文处理
+func isBcrypt(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+// Len 用户数
+func (u *Users) Len() int {
+	if u == nil {
+		return 0
+	}
+	return len(u.m)
+}
+
+// Has 是否存在该用户（不校验密码）；u 为 nil 时返回 false
+func (u *Users) Has(name string) bool {
+	if u == nil {
+		return false
+	}
+	_, ok := u.m[name]
+	return ok
+}
+
+// Check 校验用户名与密码
+func (u *Users) Check(name, pass string) bool {
+	secret, ok := u.m[name]
+	if !ok {
+		return false
+	}
+	if !isBcrypt(secret) {
+		return subtle.ConstantTimeCompare([]byte(secret), []byte(pass)) == 1
+	}
+
+	key := sha256.Sum256([]byte(name + "\x00" + pass + "\x00" + secret))
+	u.mu.Lock()
+	_, hit := u.verified[key]
+	u.mu.Unlock()
+	if hit {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(secret), []byte(pass)) != nil {
+		return false
+	}
+	u.mu.Lock()
+	u.verified[key] = struct{}{}
+	u.mu.Unlock()
+	return true
+}
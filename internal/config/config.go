@@ -2,39 +2,184 @@ package config
 
 import (
 	"flag"
+	"strings"
 	"time"
 )
 
+// stringList 可重复指定的字符串参数
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 type Config struct {
-	Listen         string        // 代理对外监听地址，例 :6808
-	APIURL         string        // 上游代理列表 API（必填）
-	FetchInterval  time.Duration // （保留旧参数）批量拉取间隔，若不用可忽略
-	AppendInterval time.Duration // 新增：每隔该时间追加 1 个代理到池子
-	TTL            time.Duration // 每个代理的生存时长
-	MetricsListen  string        // Prometheus /metrics 监听地址（留空则关闭）
+	ConfigFile string // YAML 配置文件；空则只用命令行与环境变量
+
+	values map[string][]string // 合并各层后每个参数的取值，供 Diff 比较
+
+	Listen             string        // 代理对外监听地址，例 :6808
+	SocksListen        string        // SOCKS5 入站监听地址，例 :1080（留空则关闭）
+	APIURL             string        // 上游代理列表 API（与 --source 至少指定一个）
+	Sources            []string      // 多来源 "url=...;name=...;weight=...;interval=...;ttl=...;tag=...;header=K: V;token=..."，可重复
+	FetchInterval      time.Duration // （保留旧参数）批量拉取间隔，若不用可忽略
+	AppendInterval     time.Duration // 新增：每隔该时间追加 1 个代理到池子
+	TTL                time.Duration // 每个代理的生存时长
+	SweepInterval      time.Duration // 清理过期代理的间隔
+	MetricsListen      string        // Prometheus /metrics 监听地址（留空则关闭）
+	MetricsPerUpstream bool          // 上游请求/失败数额外按单个上游计数
+	AdminListen        string        // 管理 API 监听地址（留空则关闭）
+	AdminToken         string        // 管理 API 的访问 token
+
+	// 池子快照
+	SnapshotFile     string        // 快照文件；空则不持久化
+	SnapshotInterval time.Duration // 定期保存间隔；0 只在退出时保存
 
 	// 连接/超时配置
 	DialTimeout      time.Duration
 	IdleConn         int
 	IdleTimeout      time.Duration
 	HandshakeTimeout time.Duration
+
+	// 上游健康判定
+	FailThreshold int           // 窗口内失败达到该次数才淘汰上游
+	FailWindow    time.Duration // 失败计数滑动窗口
+	Quarantine    time.Duration // >0 时先隔离该时长而非直接删除
+
+	// 池子规模
+	MinSize   int // 可用代理低于该值立即批量补充；0 关闭
+	MaxSize   int // 达到该值暂停拉取；0 不限
+	BatchSize int // 每次追加的条目数
+
+	// 入池检测
+	CheckURL         string        // 检测地址；空则不检测直接入池
+	CheckMode        string        // get|connect
+	CheckTimeout     time.Duration // 单次检测超时
+	CheckConcurrency int           // 并发检测上限（入池检测与后台检测共用）
+
+	// 出口 IP
+	ExitIPURL   string // IP 回显地址；空则不探测
+	ExitIPDedup string // off|reject|group
+
+	// 匿名等级
+	AnonymityURL string // 请求头回显地址；空则不判定
+	MinAnonymity string // transparent|anonymous|elite；空不限制
+
+	// 后台主动检测
+	HealthInterval time.Duration // 两轮检测间隔；0 关闭
+	HealthURL      string        // 检测地址；空则使用 CheckURL
+
+	// 供应商过期时间
+	ExpiryMin    time.Duration // 供应商过期时间的下限（相对入池时刻）
+	ExpiryMax    time.Duration // 供应商过期时间的上限；0 不限
+	ExpiryMargin time.Duration // 距过期不足该时长不再分配
+
+	Strategy string // 上游选择策略
+
+	// 粘性会话
+	SessionTTL  time.Duration // 会话空闲过期时长；0 关闭
+	SessionByIP bool          // 无显式会话 key 时按客户端 IP 绑定上游
+
+	// 故障转移
+	Retries      int           // 上游失败后最多再换几个上游重试
+	RetryTimeout time.Duration // 含重试在内的总截止时间
+	AllowDirect  bool          // 重试耗尽后允许直连（会暴露本机 IP）
+
+	// 严格模式：池子为空时等待入池而非直连
+	Strict    bool
+	EmptyWait time.Duration
+
+	// 入站认证
+	AuthFile  string   // htpasswd 风格用户文件（bcrypt）
+	AuthUsers []string // 内联用户 user:pass（明文或 bcrypt 哈希），可重复
+	AuthRealm string
+
+	// 入站来源 ACL（CIDR 或单个 IP，逗号分隔或重复指定）
+	AllowCIDRs []string
+	DenyCIDRs  []string
+
+	// 目标规则 "<block|direct|pool> <matcher>"，按顺序首条命中生效
+	Rules          []string
+	RuleFailClosed bool // 本地无法解析的主机名命中 block 的 cidr 规则
+
+	// https:// 上游的 TLS 选项
+	UpstreamCA       string // 额外信任的 CA（PEM）
+	UpstreamSNI      string // 覆盖 SNI 与证书校验名
+	UpstreamInsecure bool   // 跳过证书校验
 }
 
-func Parse() *Config {
-	cfg := &Config{}
+// define 在 fs 上注册全部参数，默认值即写入 cfg
+func define(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.ConfigFile, "config", "", "YAML（或 JSON）配置文件，不支持 TOML；键为参数名（如 api-url、sources、rules）；优先级：命令行 > 环境变量 PROXY_POOL_* > 配置文件 > 默认值")
+	fs.StringVar(&cfg.Listen, "listen", ":6808", "代理对外监听地址，例 :6808")
+	fs.StringVar(&cfg.SocksListen, "socks-listen", "", "SOCKS5 入站监听地址，例 :1080（留空则关闭）")
+	fs.StringVar(&cfg.APIURL, "api-url", "", "上游代理列表 API（与 --source 至少指定一个）")
+	fs.Var((*stringList)(&cfg.Sources), "source", "上游来源 \"url=...;name=...;weight=1;interval=10s;ttl=2m;tag=...;header=K: V;token=...;ca=...;sni=...;insecure=true\"，可重复；未指定的间隔/TTL 取 --append-interval/--ttl")
+	fs.DurationVar(&cfg.FetchInterval, "fetch-interval", 60*time.Second, "（保留旧参数）批量拉取间隔，若不用可忽略")
+	fs.DurationVar(&cfg.AppendInterval, "append-interval", 10*time.Second, "每隔该时间从 API 追加 1 个代理到池子")
+	fs.DurationVar(&cfg.TTL, "ttl", 2*time.Minute, "每个代理的生存时长")
+	fs.DurationVar(&cfg.SweepInterval, "sweep-interval", 30*time.Second, "清理池中过期代理的间隔")
+	fs.StringVar(&cfg.MetricsListen, "metrics-listen", "127.0.0.1:2112", "Prometheus /metrics 监听地址，默认只监听本机（留空则关闭）")
+	fs.BoolVar(&cfg.MetricsPerUpstream, "metrics-per-upstream", false, "上游请求/失败数额外按单个上游地址（去掉密码）计数；序列数随池子规模增长，代理离开池子时删除")
+	fs.StringVar(&cfg.AdminListen, "admin-listen", "", "管理 API 监听地址，例 127.0.0.1:6809（留空则关闭）")
+	fs.StringVar(&cfg.AdminToken, "admin-token", "", "管理 API 的访问 token（Authorization: Bearer 或 X-Admin-Token），开启管理 API 时必填")
+	fs.StringVar(&cfg.SnapshotFile, "snapshot-file", "", "池子快照文件（代理、过期时间、健康统计与封禁），启动时恢复未过期的条目；留空不持久化")
+	fs.DurationVar(&cfg.SnapshotInterval, "snapshot-interval", 30*time.Second, "定期保存快照的间隔；0 只在退出时保存")
+
+	fs.DurationVar(&cfg.DialTimeout, "dial-timeout", 10*time.Second, "拨号超时时间")
+	fs.IntVar(&cfg.IdleConn, "idle-conns", 100, "传输最大空闲连接数")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", 90*time.Second, "传输空闲超时时间；SOCKS5 隧道双向无数据超过该时长也会关闭")
+	fs.DurationVar(&cfg.HandshakeTimeout, "handshake-timeout", 10*time.Second, "TLS 握手超时时间")
+
+	fs.IntVar(&cfg.FailThreshold, "fail-threshold", 3, "滑动窗口内失败达到该次数才淘汰上游（1 表示首次失败即淘汰）")
+	fs.DurationVar(&cfg.FailWindow, "fail-window", time.Minute, "上游失败计数的滑动窗口")
+	fs.DurationVar(&cfg.Quarantine, "quarantine", 0, "不健康上游的隔离时长；0 表示直接从池中移除")
+
+	fs.IntVar(&cfg.MinSize, "min-size", 0, "可用代理低于该值时立即批量补充（低水位）；0 关闭")
+	fs.IntVar(&cfg.MaxSize, "max-size", 0, "池子达到该值时暂停拉取，避免浪费 API 额度；0 不限")
+	fs.IntVar(&cfg.BatchSize, "batch-size", 1, "每次追加（定时或补充）从 API 取的条目数")
+
+	fs.StringVar(&cfg.CheckURL, "check-url", "", "入池前经候选上游访问的检测地址（http:// 或 https://）；留空则不检测")
+	fs.StringVar(&cfg.CheckMode, "check-mode", "get", "检测方式：get（请求检测地址，要求 2xx）|connect（只建立到检测地址的隧道）")
+	fs.DurationVar(&cfg.CheckTimeout, "check-timeout", 10*time.Second, "单次检测超时")
+	fs.IntVar(&cfg.CheckConcurrency, "check-concurrency", 16, "同时进行的检测数上限（入池检测与后台检测共用）")
+	fs.StringVar(&cfg.ExitIPURL, "exit-ip-url", "", "入池前经候选上游请求的 IP 回显地址（JSON 的 ip/origin 字段或纯文本）；留空不探测出口 IP")
+	fs.StringVar(&cfg.ExitIPDedup, "exit-ip-dedup", "off", "出口 IP 相同的代理：off 只记录|reject 不入池|group 选择时按出口 IP 轮换")
+	fs.StringVar(&cfg.AnonymityURL, "anonymity-url", "", "入池前经候选上游请求的请求头回显地址（如 httpbin 的 /get），据此判定 transparent/anonymous/elite；留空不判定")
+	fs.StringVar(&cfg.MinAnonymity, "min-anonymity", "", "只使用不低于该匿名等级的上游：transparent|anonymous|elite；留空不限制（需 --anonymity-url）")
+	fs.DurationVar(&cfg.HealthInterval, "health-interval", 0, "后台主动检测池中所有上游的间隔；0 关闭")
+	fs.StringVar(&cfg.HealthURL, "health-url", "", "后台检测地址；留空使用 --check-url")
+
+	fs.DurationVar(&cfg.ExpiryMin, "expiry-min", 0, "API 条目自带过期时间时的下限（相对入池时刻），防止时钟偏差导致刚入池即过期")
+	fs.DurationVar(&cfg.ExpiryMax, "expiry-max", 0, "API 条目自带过期时间时的上限；0 不限")
+	fs.DurationVar(&cfg.ExpiryMargin, "expiry-margin", 0, "距过期不足该时长的代理不再分配给新请求（在途连接不受影响）")
+
+	fs.StringVar(&cfg.Strategy, "strategy", "round-robin", "上游选择策略：round-robin|random|weighted|least-inflight|ewma|p2c")
+
+	fs.DurationVar(&cfg.SessionTTL, "session-ttl", 10*time.Minute, "粘性会话空闲过期时长（X-Proxy-Session 头或用户名 user-session-xxx）；0 关闭")
+	fs.BoolVar(&cfg.SessionByIP, "session-by-ip", false, "无显式会话 key 时按客户端 IP 固定上游")
+
+	fs.IntVar(&cfg.Retries, "retries", 2, "上游失败后最多再换几个上游重试")
+	fs.DurationVar(&cfg.RetryTimeout, "retry-timeout", 30*time.Second, "含重试在内的总截止时间（到拿到响应头/隧道建立为止）")
+	fs.BoolVar(&cfg.AllowDirect, "allow-direct", false, "重试耗尽后允许直连目标（会暴露本机出口 IP）；默认返回 502")
+
+	fs.BoolVar(&cfg.Strict, "strict", false, "严格模式：池子为空时绝不直连，立即触发拉取并等待新代理入池，超时返回 503 Pool Empty")
+	fs.DurationVar(&cfg.EmptyWait, "empty-wait", 5*time.Second, "严格模式下池子为空时最多等待的时长")
+
+	fs.StringVar(&cfg.AuthFile, "auth-file", "", "htpasswd 风格用户文件（每行 user:bcrypt哈希）；与 --auth-user 均为空则不启用认证")
+	fs.Var((*stringList)(&cfg.AuthUsers), "auth-user", "内联用户 user:pass（明文或 bcrypt 哈希），可重复指定")
+	fs.StringVar(&cfg.AuthRealm, "auth-realm", "proxy-pool", "407 质询中的 realm")
 
-	flag.StringVar(&cfg.Listen, "listen", ":6808", "代理对外监听地址，例 :6808")
-	flag.StringVar(&cfg.APIURL, "api-url", "", "上游代理列表 API（必填）")
-	flag.DurationVar(&cfg.FetchInterval, "fetch-interval", 60*time.Second, "（保留旧参数）批量拉取间隔，若不用可忽略")
-	flag.DurationVar(&cfg.AppendInterval, "append-interval", 10*time.Second, "每隔该时间从 API 追加 1 个代理到池子")
-	flag.DurationVar(&cfg.TTL, "ttl", 2*time.Minute, "每个代理的生存时长")
-	flag.StringVar(&cfg.MetricsListen, "metrics-listen", ":2112", "Prometheus /metrics 监听地址（留空则关闭）")
+	fs.Var((*stringList)(&cfg.AllowCIDRs), "allow-cidr", "允许连接的来源网段（CIDR 或 IP，逗号分隔，可重复）；为空表示不限制")
+	fs.Var((*stringList)(&cfg.DenyCIDRs), "deny-cidr", "拒绝连接的来源网段（优先于 --allow-cidr）")
 
-	flag.DurationVar(&cfg.DialTimeout, "dial-timeout", 10*time.Second, "拨号超时时间")
-	flag.IntVar(&cfg.IdleConn, "idle-conns", 100, "传输最大空闲连接数")
-	flag.DurationVar(&cfg.IdleTimeout, "idle-timeout", 90*time.Second, "传输空闲超时时间")
-	flag.DurationVar(&cfg.HandshakeTimeout, "handshake-timeout", 10*time.Second, "TLS 握手超时时间")
+	fs.Var((*stringList)(&cfg.Rules), "rule", "目标规则 \"<block|direct|pool> <matcher>\"，可重复，按顺序首条命中生效；matcher: host:/*./suffix:/regex:/cidr:/port:")
+	fs.BoolVar(&cfg.RuleFailClosed, "rule-fail-closed", false, "目标主机名在本地无法解析时，block 的 cidr 规则视为命中（拒绝而不是放行）")
 
-	flag.Parse()
-	return cfg
+	fs.StringVar(&cfg.UpstreamCA, "upstream-ca", "", "https:// 上游额外信任的 CA 证书（PEM）")
+	fs.StringVar(&cfg.UpstreamSNI, "upstream-sni", "", "https:// 上游的 SNI（同时作为证书校验名）；默认取上游主机名")
+	fs.BoolVar(&cfg.UpstreamInsecure, "upstream-insecure", false, "https:// 上游跳过证书校验")
 }
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// static 需要重启才能生效的参数：监听地址、持久化文件、传输层连接池参数
+var static = map[string]bool{
+	"config":               true,
+	"listen":               true,
+	"socks-listen":         true,
+	"metrics-listen":       true,
+	"metrics-per-upstream": true,
+	"admin-listen":         true,
+	"admin-token":          true,
+	"snapshot-file":        true,
+	"fetch-interval":       true,
+	"dial-timeout":         true,
+	"idle-conns":           true,
+	"idle-timeout":         true,
+	"handshake-timeout":    true,
+}
+
+// 日志中不打印取值的参数
+var secret = map[string]bool{
+	"admin-token": true,
+	"auth-user":   true,
+}
+
+// 来源里的 token 与请求头取值（多为 API key）
+var sourceSecret = regexp.MustCompile(`(?i)((?:^|;)\s*(?:token\s*=|header\s*=[^:;]*:))[^;]*`)
+
+// listFlags 可重复指定的参数
+var listFlags = func() map[string]struct{} {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	define(fs, &Config{})
+	m := make(map[string]struct{})
+	fs.VisitAll(func(f *flag.Flag) {
+		if isList(f) {
+			m[f.Name] = struct{}{}
+		}
+	})
+	return m
+}()
+
+// Change 一个参数在两份配置之间的变化；敏感取值已脱敏
+type Change struct {
+	Flag     string
+	Old, New string
+	Restart  bool // 需要重启才能生效，热加载时保留旧值
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("--%s: %s -> %s", c.Flag, c.Old, c.New)
+	if c.Restart {
+		s += " (needs restart, ignored)"
+	}
+	return s
+}
+
+// Diff 按参数名比较两份由 Load 得到的配置，返回取值不同的参数（按名称排序）
+func Diff(old, cur *Config) []Change {
+	var out []Change
+	for _, name := range flagNames(old, cur) {
+		a, b := old.values[name], cur.values[name]
+		if slices.Equal(a, b) {
+			continue
+		}
+		out = append(out, Change{Flag: name, Old: show(name, a), New: show(name, b), Restart: static[name]})
+	}
+	return out
+}
+
+func flagNames(cfgs ...*Config) []string {
+	var names []string
+	for _, c := range cfgs {
+		for name := range c.values {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// show 取值的日志形式：列表逐项加引号；敏感参数只显示个数，来源中的 token 与请求头取值替换为 ***
+func show(name string, vals []string) string {
+	if secret[name] && len(vals) > 0 && !(len(vals) == 1 && vals[0] == "") {
+		return fmt.Sprintf("(%d redacted)", len(vals))
+	}
+	quoted := make([]string, len(vals))
+	for i, v := range vals {
+		if name == "source" {
+			v = sourceSecret.ReplaceAllString(v, "${1}***")
+		}
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	if _, ok := listFlags[name]; ok {
+		return "[" + strings.Join(quoted, " ") + "]"
+	}
+	if len(quoted) == 0 {
+		return `""`
+	}
+	return quoted[0]
+}
+
+// KeepStatic 把需要重启才能生效的参数恢复为 old 中的取值，使 c 与正在运行的进程一致
+func (c *Config) KeepStatic(old *Config) {
+	c.ConfigFile = old.ConfigFile
+	c.Listen, c.SocksListen = old.Listen, old.SocksListen
+	c.MetricsListen, c.AdminListen, c.AdminToken = old.MetricsListen, old.AdminListen, old.AdminToken
+	c.MetricsPerUpstream = old.MetricsPerUpstream
+	c.SnapshotFile = old.SnapshotFile
+	c.FetchInterval = old.FetchInterval
+	c.DialTimeout, c.IdleConn, c.IdleTimeout, c.HandshakeTimeout = old.DialTimeout, old.IdleConn, old.IdleTimeout, old.HandshakeTimeout
+	for name := range static {
+		if v, ok := old.values[name]; ok {
+			c.values[name] = v
+		}
+	}
+}
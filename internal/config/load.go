@@ -0,0 +1,349 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// EnvPrefix 环境变量前缀：参数 api-url 对应 PROXY_POOL_API_URL
+const EnvPrefix = "PROXY_POOL_"
+
+// 配置文件中可用的复数别名
+var fileAliases = map[string]string{
+	"sources":     "source",
+	"rules":       "rule",
+	"users":       "auth-user",
+	"auth-users":  "auth-user",
+	"allow-cidrs": "allow-cidr",
+	"deny-cidrs":  "deny-cidr",
+}
+
+// Parse 解析命令行、环境变量与配置文件；有错误时一次性打印全部错误并退出
+func Parse() *Config {
+	cfg, err := Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	return cfg
+}
+
+// Load 按 命令行 > 环境变量 > 配置文件 > 默认值 的优先级合并配置并校验。
+// 每个参数只取优先级最高的一层；可重复的参数（如 source、rule）也是整体覆盖而非追加。
+// 返回的错误包含全部问题（errors.Join）。
+func Load(args []string) (*Config, error) {
+	cfg := &Config{}
+	fs := flag.NewFlagSet("proxy-pool", flag.ContinueOnError)
+	define(fs, cfg)
+	var errs []error
+	if err := parseArgs(fs, args, &errs); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, errors.Join(append(errs, err)...)
+	}
+	if fs.NArg() > 0 {
+		return nil, errors.Join(append(errs, fmt.Errorf("unexpected arguments: %q", fs.Args()))...)
+	}
+
+	set := make(map[string]bool) // 已由更高优先级的层设置
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	errs = append(errs, applyEnv(fs, set)...)
+	if cfg.ConfigFile != "" {
+		errs = append(errs, applyFile(fs, cfg.ConfigFile, set)...)
+	}
+	errs = append(errs, cfg.validate()...)
+
+	cfg.values = make(map[string][]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		if l, ok := f.Value.(*stringList); ok {
+			cfg.values[f.Name] = slices.Clone(*l)
+		} else {
+			cfg.values[f.Name] = []string{f.Value.String()}
+		}
+	})
+	return cfg, errors.Join(errs...)
+}
+
+// parseArgs 解析命令行。取值错误（如 --ttl=abc）记入 errs 并继续解析后面的参数，
+// 与环境变量、配置文件和校验的错误一起报告；未知参数、-h 这类错误仍立即返回
+func parseArgs(fs *flag.FlagSet, args []string, errs *[]error) error {
+	fs.VisitAll(func(f *flag.Flag) {
+		f.Value = &collectValue{Value: f.Value, name: f.Name, errs: errs}
+	})
+	unwrap := func() {
+		fs.VisitAll(func(f *flag.Flag) {
+			if c, ok := f.Value.(*collectValue); ok {
+				f.Value = c.Value
+			}
+		})
+	}
+	defer unwrap()
+	fs.Usage = func() {
+		unwrap() // 帮助信息按参数原本的类型显示类型名与默认值
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	return fs.Parse(args)
+}
+
+// collectValue 包装参数的 Value：Set 失败时记下错误并保留原值，不中断 fs.Parse
+type collectValue struct {
+	flag.Value
+	name string
+	errs *[]error
+}
+
+func (v *collectValue) Set(val string) error {
+	if err := setValue(v.Value, val); err != nil {
+		*v.errs = append(*v.errs, fmt.Errorf("--%s: %w", v.name, err))
+	}
+	return nil
+}
+
+// IsBoolFlag 保持布尔参数可以不带值（--insecure）
+func (v *collectValue) IsBoolFlag() bool {
+	b, ok := v.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// EnvName 参数对应的环境变量名
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+func isList(f *flag.Flag) bool {
+	_, ok := f.Value.(*stringList)
+	return ok
+}
+
+// applyEnv 用 PROXY_POOL_* 设置命令行未指定的参数；可重复的参数每行一个值
+func applyEnv(fs *flag.FlagSet, set map[string]bool) []error {
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] {
+			return
+		}
+		name := EnvName(f.Name)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		set[f.Name] = true
+		vals := []string{v}
+		if isList(f) {
+			vals = splitLines(v)
+		}
+		for _, val := range vals {
+			if err := set1(fs, f.Name, val); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	})
+	return errs
+}
+
+// set1 设置一个参数值
+func set1(fs *flag.FlagSet, name, val string) error {
+	return setValue(fs.Lookup(name).Value, val)
+}
+
+// setValue 解析失败时恢复原值（flag 的数值类型失败时会写入零值），
+// 避免后续校验对同一个参数再报一遍
+func setValue(v flag.Value, val string) error {
+	prev := v.String()
+	if err := v.Set(val); err != nil {
+		if _, ok := v.(*stringList); !ok {
+			_ = v.Set(prev)
+		}
+		return fmt.Errorf("invalid value %q: %w", val, err)
+	}
+	return nil
+}
+
+func splitLines(v string) []string {
+	var out []string
+	for _, line := range strings.Split(v, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// applyFile 用配置文件设置命令行与环境变量都未指定的参数。
+// 键为参数名（"-" 或 "_" 均可），可重复的参数写成列表；sources 的元素可以是字符串或映射。
+// 只支持 YAML（JSON 是其子集）；.toml 文件直接报错，而不是给出令人费解的 YAML 语法错误。
+func applyFile(fs *flag.FlagSet, path string, set map[string]bool) []error {
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		return []error{fmt.Errorf("%s: TOML is not supported, use YAML (or JSON)", path)}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []error{fmt.Errorf("read config: %w", err)}
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return []error{fmt.Errorf("%s: %w", path, err)}
+	}
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		name := strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+		if alias, ok := fileAliases[name]; ok {
+			name = alias
+		}
+		f := fs.Lookup(name)
+		if f == nil || name == "config" {
+			errs = append(errs, fmt.Errorf("%s: unknown key %q", path, key))
+			continue
+		}
+		vals, err := fileValues(name, raw[key])
+		if err == nil && !isList(f) && len(vals) != 1 {
+			err = errors.New("expects a single value")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, key, err))
+			continue
+		}
+		if set[name] {
+			continue
+		}
+		set[name] = true
+		for _, val := range vals {
+			if err := set1(fs, name, val); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", path, key, err))
+			}
+		}
+	}
+	return errs
+}
+
+// fileValues 把配置文件中的值转换成参数字符串；列表展开为多个值
+func fileValues(name string, v any) ([]string, error) {
+	items, ok := v.([]any)
+	if !ok {
+		items = []any{v}
+	}
+	if m, ok := v.(map[any]any); ok && name == "auth-user" {
+		// users: {alice: secret, bob: "$2y$..."}
+		items = items[:0]
+		for _, e := range entries(m) {
+			items = append(items, e.key+":"+scalar(e.val))
+		}
+	}
+	out := make([]string, 0, len(items))
+	for _, it := range items {
+		switch x := it.(type) {
+		case nil:
+		case map[any]any:
+			if name != "source" {
+				return nil, errors.New("unexpected mapping")
+			}
+			spec, err := sourceSpec(x)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, spec)
+		case []any:
+			return nil, errors.New("unexpected nested list")
+		default:
+			out = append(out, scalar(x))
+		}
+	}
+	return out, nil
+}
+
+// sourceSpec 把映射形式的来源转换成 --source 的 "k=v;..." 形式。
+// headers 与 label 可写成映射，labels 可写成列表。
+func sourceSpec(m map[any]any) (string, error) {
+	var parts []string
+	add := func(k, v string) error {
+		if strings.Contains(v, ";") {
+			return fmt.Errorf("source %s: value must not contain ';'", k)
+		}
+		parts = append(parts, k+"="+v)
+		return nil
+	}
+	for _, e := range entries(m) {
+		k, v := e.key, e.val
+		switch k {
+		case "header", "headers":
+			hs, ok := v.(map[any]any)
+			if !ok {
+				return "", fmt.Errorf("source %s: want a mapping", k)
+			}
+			for _, h := range entries(hs) {
+				if err := add("header", h.key+": "+scalar(h.val)); err != nil {
+					return "", err
+				}
+			}
+		case "label":
+			ls, ok := v.(map[any]any)
+			if !ok {
+				return "", errors.New("source label: want a mapping")
+			}
+			for _, l := range entries(ls) {
+				if err := add("label."+l.key, scalar(l.val)); err != nil {
+					return "", err
+				}
+			}
+		case "labels":
+			if list, ok := v.([]any); ok {
+				names := make([]string, 0, len(list))
+				for _, n := range list {
+					names = append(names, scalar(n))
+				}
+				v = strings.Join(names, ",")
+			}
+			if err := add(k, scalar(v)); err != nil {
+				return "", err
+			}
+		default:
+			if err := add(k, scalar(v)); err != nil {
+				return "", err
+			}
+		}
+	}
+	return strings.Join(parts, ";"), nil
+}
+
+type entry struct {
+	key string
+	val any
+}
+
+// entries 按键排序的映射项；YAML 的键可能不是字符串（如数字），统一转成字符串
+func entries(m map[any]any) []entry {
+	out := make([]entry, 0, len(m))
+	for k, v := range m {
+		out = append(out, entry{key: scalar(k), val: v})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].key < out[j].key })
+	return out
+}
+
+// scalar 把 YAML 标量转成字符串
+func scalar(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadCollectsFlagErrors(t *testing.T) {
+	_, err := Load([]string{"--ttl=abc", "--retries=x", "--api-url=http://api.example", "--min-size=2", "--max-size=1"})
+	if err == nil {
+		t.Fatal("Load: want error")
+	}
+	for _, want := range []string{`--ttl: invalid value "abc"`, `--retries: invalid value "x"`, "--min-size (2) must not exceed --max-size (1)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	if _, err := Load([]string{"-h"}); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("Load(-h) = %v, want flag.ErrHelp", err)
+	}
+}
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "proxy-pool.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 命令行 > 环境变量 > 配置文件 > 默认值；可重复的参数整体覆盖而不是追加
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfig(t, `api_url: http://file.example/api
+ttl: 5m
+retries: 4
+rules:
+  - block suffix:file.example
+`)
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		apiURL  string
+		ttl     time.Duration
+		retries int
+		rules   []string
+	}{
+		{"defaults", []string{"--api-url=http://cli.example/api"}, nil,
+			"http://cli.example/api", 2 * time.Minute, 2, nil},
+		{"file", []string{"--config=" + file}, nil,
+			"http://file.example/api", 5 * time.Minute, 4, []string{"block suffix:file.example"}},
+		{"config file from env", nil, map[string]string{"PROXY_POOL_CONFIG": file},
+			"http://file.example/api", 5 * time.Minute, 4, []string{"block suffix:file.example"}},
+		{"env over file", []string{"--config=" + file}, map[string]string{
+			"PROXY_POOL_TTL":  "3m",
+			"PROXY_POOL_RULE": "block suffix:env1.example\n\n  block suffix:env2.example  \n",
+		}, "http://file.example/api", 3 * time.Minute, 4, []string{"block suffix:env1.example", "block suffix:env2.example"}},
+		{"cli over env", []string{"--config=" + file, "--ttl=1m", "--rule=direct host:cli.example", "--api-url=http://cli.example/api"}, map[string]string{
+			"PROXY_POOL_TTL":     "3m",
+			"PROXY_POOL_RULE":    "block suffix:env.example",
+			"PROXY_POOL_API_URL": "http://env.example/api",
+		}, "http://cli.example/api", time.Minute, 4, []string{"direct host:cli.example"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := Load(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.APIURL != tt.apiURL || cfg.TTL != tt.ttl || cfg.Retries != tt.retries || !slices.Equal(cfg.Rules, tt.rules) {
+				t.Fatalf("got api-url=%s ttl=%s retries=%d rules=%q, want %s %s %d %q",
+					cfg.APIURL, cfg.TTL, cfg.Retries, cfg.Rules, tt.apiURL, tt.ttl, tt.retries, tt.rules)
+			}
+		})
+	}
+}
+
+func TestLoadFileSources(t *testing.T) {
+	file := writeConfig(t, `sources:
+  - http://a.example/api
+  - url: http://b.example/list
+    name: b
+    weight: 2
+    headers: {X-Api-Key: KEY}
+    label: {country: cc}
+    labels: [city, isp]
+users:
+  alice: secret
+`)
+	cfg, err := Load([]string{"--config=" + file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"http://a.example/api",
+		"header=X-Api-Key: KEY;label.country=cc;labels=city,isp;name=b;url=http://b.example/list;weight=2",
+	}
+	if !slices.Equal(cfg.Sources, want) {
+		t.Fatalf("sources = %q, want %q", cfg.Sources, want)
+	}
+	if !slices.Equal(cfg.AuthUsers, []string{"alice:secret"}) {
+		t.Fatalf("auth users = %q", cfg.AuthUsers)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	file := writeConfig(t, `ttl: soon
+colour: blue
+retries: [1, 2]
+`)
+	t.Setenv("PROXY_POOL_BATCH_SIZE", "many")
+	_, err := Load([]string{"--config=" + file, "--api-url=http://api.example", "--fail-threshold=0"})
+	if err == nil {
+		t.Fatal("Load: want error")
+	}
+	for _, want := range []string{
+		`PROXY_POOL_BATCH_SIZE: invalid value "many"`,
+		file + `: ttl: invalid value "soon"`,
+		file + `: unknown key "colour"`,
+		file + ": retries: expects a single value",
+		"--fail-threshold must be at least 1",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	// 解析失败的参数保留原值，不会再被校验重复报告
+	if strings.Contains(err.Error(), "--batch-size") || strings.Contains(err.Error(), "--ttl must") {
+		t.Errorf("error %q reports an invalid value twice", err)
+	}
+}
+
+func TestLoadTOMLFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy-pool.toml")
+	if err := os.WriteFile(path, []byte("api-url = \"http://api.example\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Load([]string{"--config=" + path})
+	if err == nil || !strings.Contains(err.Error(), "TOML is not supported") {
+		t.Fatalf("Load(.toml) error = %v", err)
+	}
+}
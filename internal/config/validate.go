@@ -0,0 +1,158 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/acl"
+	"github.com/lianshufeng/proxy-pool/internal/auth"
+	"github.com/lianshufeng/proxy-pool/internal/fetcher"
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+	"github.com/lianshufeng/proxy-pool/internal/probe"
+	"github.com/lianshufeng/proxy-pool/internal/upstream"
+)
+
+// validate 检查参数取值与参数之间的依赖，返回全部问题而不是遇到第一个就停
+func (c *Config) validate() []error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if strings.TrimSpace(c.Listen) == "" {
+		add("--listen must not be empty")
+	}
+	errs = append(errs, c.validateSources()...)
+
+	for _, d := range []struct {
+		name string
+		v    time.Duration
+	}{
+		{"ttl", c.TTL},
+		{"sweep-interval", c.SweepInterval},
+		{"dial-timeout", c.DialTimeout},
+		{"retry-timeout", c.RetryTimeout},
+		{"check-timeout", c.CheckTimeout},
+	} {
+		if d.v <= 0 {
+			add("--%s must be positive, got %s", d.name, d.v)
+		}
+	}
+	for _, d := range []struct {
+		name string
+		v    time.Duration
+	}{
+		{"append-interval", c.AppendInterval},
+		{"idle-timeout", c.IdleTimeout},
+		{"handshake-timeout", c.HandshakeTimeout},
+		{"fail-window", c.FailWindow},
+		{"quarantine", c.Quarantine},
+		{"snapshot-interval", c.SnapshotInterval},
+		{"health-interval", c.HealthInterval},
+		{"expiry-min", c.ExpiryMin},
+		{"expiry-max", c.ExpiryMax},
+		{"expiry-margin", c.ExpiryMargin},
+		{"session-ttl", c.SessionTTL},
+		{"empty-wait", c.EmptyWait},
+	} {
+		if d.v < 0 {
+			add("--%s must not be negative, got %s", d.name, d.v)
+		}
+	}
+	for _, n := range []struct {
+		name string
+		v    int
+		min  int
+	}{
+		{"idle-conns", c.IdleConn, 0},
+		{"retries", c.Retries, 0},
+		{"min-size", c.MinSize, 0},
+		{"max-size", c.MaxSize, 0},
+		{"batch-size", c.BatchSize, 1},
+		{"check-concurrency", c.CheckConcurrency, 1},
+		{"fail-threshold", c.FailThreshold, 1},
+	} {
+		if n.v < n.min {
+			add("--%s must be at least %d, got %d", n.name, n.min, n.v)
+		}
+	}
+	if c.MaxSize > 0 && c.MinSize > c.MaxSize {
+		add("--min-size (%d) must not exceed --max-size (%d)", c.MinSize, c.MaxSize)
+	}
+	if c.ExpiryMax > 0 && c.ExpiryMin > c.ExpiryMax {
+		add("--expiry-min (%s) must not exceed --expiry-max (%s)", c.ExpiryMin, c.ExpiryMax)
+	}
+
+	if _, err := pool.NewSelector(c.Strategy); err != nil {
+		add("--strategy: %v", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(c.CheckMode)) {
+	case "", probe.ModeGet, probe.ModeConnect:
+	default:
+		add("--check-mode: unknown mode %q (supported: %s, %s)", c.CheckMode, probe.ModeGet, probe.ModeConnect)
+	}
+	switch c.ExitIPDedup {
+	case pool.ExitIPKeep, pool.ExitIPReject, pool.ExitIPGroup:
+		if c.ExitIPDedup != pool.ExitIPKeep && c.ExitIPURL == "" {
+			add("--exit-ip-dedup=%s needs --exit-ip-url", c.ExitIPDedup)
+		}
+	default:
+		add("--exit-ip-dedup: unknown value %q (supported: off, reject, group)", c.ExitIPDedup)
+	}
+	if minAnon, err := pool.ParseAnonymity(c.MinAnonymity); err != nil {
+		add("--min-anonymity: %v", err)
+	} else if minAnon > pool.AnonymityUnknown && c.AnonymityURL == "" {
+		add("--min-anonymity needs --anonymity-url")
+	}
+	if c.HealthInterval > 0 && c.HealthURL == "" && c.CheckURL == "" {
+		add("--health-interval needs --health-url or --check-url")
+	}
+	if c.AdminListen != "" && c.AdminToken == "" {
+		add("--admin-listen needs --admin-token")
+	}
+
+	if _, err := auth.Load(c.AuthFile, c.AuthUsers); err != nil {
+		add("auth users: %v", err)
+	}
+	if _, err := acl.NewSourceACL(c.AllowCIDRs, c.DenyCIDRs); err != nil {
+		add("source acl: %v", err)
+	}
+	if _, err := acl.ParseRules(c.Rules, c.DialTimeout, c.RuleFailClosed); err != nil {
+		add("--rule: %v", err)
+	}
+	if _, err := upstream.TLSConfig(c.UpstreamCA, c.UpstreamSNI, c.UpstreamInsecure); err != nil {
+		add("upstream tls: %v", err)
+	}
+	return errs
+}
+
+// validateSources 检查 --api-url 与 --source：至少一个来源，每条 --source 可解析，名称唯一
+func (c *Config) validateSources() []error {
+	var errs []error
+	names := make(map[string]struct{})
+	if c.APIURL != "" {
+		names["api"] = struct{}{}
+	}
+	iv := c.AppendInterval
+	if iv <= 0 {
+		iv = 10 * time.Second
+	}
+	def := fetcher.Source{Interval: iv, TTL: c.TTL, Weight: 1}
+	for _, spec := range c.Sources {
+		src, err := fetcher.ParseSource(spec, def)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("--source: %w", err))
+			continue
+		}
+		if _, dup := names[src.Name]; dup {
+			errs = append(errs, fmt.Errorf("--source: duplicate source name %q", src.Name))
+		}
+		names[src.Name] = struct{}{}
+	}
+	if c.APIURL == "" && len(c.Sources) == 0 {
+		errs = append(errs, errors.New("missing --api-url or --source"))
+	}
+	return errs
+}
@@ -2,6 +2,7 @@ package fetcher
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -9,32 +10,62 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/metrics"
 )
 
 type Fetcher struct {
-	apiURL string
+	src    Source
 	client *http.Client
 
-	cache  []string
+	cache  []Entry
 	cursor int
 }
 
 func New(apiURL string, dialTimeout time.Duration) *Fetcher {
+	return NewSource(Source{URL: apiURL}, dialTimeout)
+}
+
+// NewSource 按来源配置创建 Fetcher，拉取时附带来源的请求头与 token
+func NewSource(src Source, dialTimeout time.Duration) *Fetcher {
 	return &Fetcher{
-		apiURL: apiURL,
+		src:    src,
 		client: &http.Client{Timeout: dialTimeout},
 	}
 }
 
-// FetchList 拉取一次 API，支持 JSON 数组或换行文本
-func (f *Fetcher) FetchList(ctx context.Context) ([]string, error) {
-	if f.apiURL == "" {
+// Source 返回该 Fetcher 的来源配置
+func (f *Fetcher) Source() Source {
+	return f.src
+}
+
+// FetchList 拉取一次 API，支持 JSON 字符串数组、带字段映射的 JSON 对象列表或换行文本
+func (f *Fetcher) FetchList(ctx context.Context) ([]Entry, error) {
+	start := time.Now()
+	list, err := f.fetchList(ctx)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	metrics.Fetches.WithLabelValues(f.src.Name, result).Inc()
+	metrics.FetchDuration.WithLabelValues(f.src.Name).Observe(time.Since(start).Seconds())
+	return list, err
+}
+
+func (f *Fetcher) fetchList(ctx context.Context) ([]Entry, error) {
+	if f.src.URL == "" {
 		return nil, errors.New("empty api url")
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.apiURL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.src.URL, nil)
 	if err != nil {
 		return nil, err
 	}
+	for k, v := range f.src.Headers {
+		req.Header[k] = v
+	}
+	if f.src.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.src.Token)
+	}
 	resp, err := f.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -50,64 +81,77 @@ func (f *Fetcher) FetchList(ctx context.Context) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	return f.parse(body)
+}
 
-	// 优先解析 JSON
-	var arr []string
-	if err := json.Unmarshal(body, &arr); err == nil && len(arr) > 0 {
-		return normalize(arr), nil
+// parse 解析响应体：JSON（字符串数组，或按 Mapping 提取对象列表）或换行文本
+func (f *Fetcher) parse(body []byte) ([]Entry, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && (body[0] == '[' || body[0] == '{') {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		var doc any
+		if err := dec.Decode(&doc); err == nil {
+			list, err := f.src.Mapping.entries(doc)
+			if err != nil {
+				return nil, err
+			}
+			return normalize(list), nil
+		}
 	}
 
 	// 退化为按行文本
 	return readLines(string(body)), nil
 }
 
-func readLines(s string) []string {
-	var res []string
+func readLines(s string) []Entry {
+	var res []Entry
 	sc := bufio.NewScanner(strings.NewReader(s))
 	for sc.Scan() {
 		line := strings.TrimSpace(sc.Text())
 		if line != "" {
-			res = append(res, line)
+			res = append(res, Entry{Addr: line})
 		}
 	}
 	return normalize(res)
 }
 
-func normalize(in []string) []string {
-	out := make([]string, 0, len(in))
+// normalize 去掉空地址并按地址去重，保留首次出现的条目
+func normalize(in []Entry) []Entry {
+	out := make([]Entry, 0, len(in))
 	seen := make(map[string]struct{}, len(in))
-	for _, s := range in {
-		s = strings.TrimSpace(s)
-		if s == "" {
+	for _, e := range in {
+		e.Addr = strings.TrimSpace(e.Addr)
+		if e.Addr == "" {
 			continue
 		}
 		// 不再强行补 "http://"
-		if _, ok := seen[s]; ok {
+		if _, ok := seen[e.Addr]; ok {
 			continue
 		}
-		seen[s] = struct{}{}
-		out = append(out, s)
+		seen[e.Addr] = struct{}{}
+		out = append(out, e)
 	}
 	return out
 }
 
-// Next 每次返回一个地址；缓存耗尽则自动重新拉取
-func (f *Fetcher) Next(ctx context.Context) (string, error) {
+// Next 每次返回一个条目；缓存耗尽则自动重新拉取
+func (f *Fetcher) Next(ctx context.Context) (Entry, error) {
 	if f.cursor < len(f.cache) {
-		addr := f.cache[f.cursor]
+		e := f.cache[f.cursor]
 		f.cursor++
-		return addr, nil
+		return e, nil
 	}
 	list, err := f.FetchList(ctx)
 	if err != nil {
-		return "", err
+		return Entry{}, err
 	}
 	if len(list) == 0 {
-		return "", errors.New("empty proxy list from api")
+		return Entry{}, errors.New("empty proxy list from api")
 	}
 	f.cache = list
 	f.cursor = 0
-	addr := f.cache[f.cursor]
+	e := f.cache[f.cursor]
 	f.cursor++
-	return addr, nil
+	return e, nil
 }
@@ -0,0 +1,111 @@
+package fetcher
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Source 一个上游代理来源（供应商 API）及其独立配置
+type Source struct {
+	Name     string        // 来源名称，用于日志、指标与按来源的 TLS 配置；需唯一
+	URL      string        // 代理列表 API
+	Headers  http.Header   // 拉取时附带的请求头
+	Token    string        // 非空时附带 Authorization: Bearer <token>
+	Interval time.Duration // 追加间隔
+	TTL      time.Duration // 该来源代理的生存时长
+	Weight   int           // 来源权重（weighted 策略下按来源分配流量）
+	Tag      string        // 附加在代理上的标签
+	Mapping  Mapping       // 结构化 JSON 的字段映射
+
+	// 该来源中 https:// 上游的 TLS 选项；全为空时沿用全局 --upstream-*
+	UpstreamCA       string
+	UpstreamSNI      string
+	UpstreamInsecure bool
+}
+
+// HasTLS 是否设置了来源级 TLS 选项
+func (s Source) HasTLS() bool {
+	return s.UpstreamCA != "" || s.UpstreamSNI != "" || s.UpstreamInsecure
+}
+
+// ParseSource 解析 --source 参数："url=...;name=...;weight=3;interval=5s;ttl=2m;tag=x;header=K: V;token=..."，
+// 以及字段映射 "list=data.list;host=ip;port=port;expire=expire_time;label.city=city"。
+// 不是已知 key 的第一段视为 URL，因此 "http://host/api?a=1;weight=2" 也可以。未指定的字段取自 def。
+func ParseSource(spec string, def Source) (Source, error) {
+	src := def
+	src.Headers = make(http.Header)
+	for k, v := range def.Headers {
+		src.Headers[k] = append([]string(nil), v...)
+	}
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, val, _ := strings.Cut(part, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+		var err error
+		switch key {
+		case "url":
+			src.URL = val
+		case "name":
+			src.Name = val
+		case "tag":
+			src.Tag = val
+		case "token":
+			src.Token = val
+		case "header":
+			k, v, ok := strings.Cut(val, ":")
+			if !ok || strings.TrimSpace(k) == "" {
+				return src, fmt.Errorf("source %q: header must be \"Key: Value\"", spec)
+			}
+			src.Headers.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+		case "weight":
+			src.Weight, err = strconv.Atoi(val)
+		case "interval":
+			src.Interval, err = time.ParseDuration(val)
+		case "ttl":
+			src.TTL, err = time.ParseDuration(val)
+		case "ca":
+			src.UpstreamCA = val
+		case "sni":
+			src.UpstreamSNI = val
+		case "insecure":
+			src.UpstreamInsecure, err = strconv.ParseBool(val)
+		default:
+			if src.Mapping.set(key, val) {
+				break
+			}
+			if src.URL != "" {
+				return src, fmt.Errorf("source %q: unknown key %q", spec, key)
+			}
+			src.URL = part
+		}
+		if err != nil {
+			return src, fmt.Errorf("source %q: %s: %w", spec, key, err)
+		}
+	}
+
+	if src.URL == "" {
+		return src, fmt.Errorf("source %q: missing url", spec)
+	}
+	u, err := url.Parse(src.URL)
+	if err != nil || u.Host == "" {
+		return src, fmt.Errorf("source %q: invalid url %q", spec, src.URL)
+	}
+	if src.Name == "" {
+		src.Name = u.Host
+	}
+	if src.Weight <= 0 {
+		src.Weight = 1
+	}
+	if src.Interval <= 0 || src.TTL <= 0 {
+		return src, fmt.Errorf("source %q: interval and ttl must be positive", spec)
+	}
+	return src, nil
+}
@@ -0,0 +1,157 @@
+// internal/metrics/collectors.go
+package metrics
+
+import (
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// 指标统一使用 proxy_pool_ 前缀，注册到默认 registry，由 Start 暴露
+
+// RejectedConns 被来源 ACL 拒绝的入站连接数
+var RejectedConns = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_pool_rejected_connections_total",
+	Help: "Inbound connections rejected by the source CIDR ACL.",
+}, []string{"listener", "reason"})
+
+// ProxiesAdded 新入池的代理数
+var ProxiesAdded = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_pool_proxies_added_total",
+	Help: "Proxies added to the pool, by source.",
+}, []string{"source"})
+
+// ProxiesRejected 入池时被拒绝的代理数；reason 为 check-failed / expiring / duplicate-exit-ip / low-anonymity 等
+var ProxiesRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_pool_proxies_rejected_total",
+	Help: "Candidate proxies not admitted to the pool, by source and reason.",
+}, []string{"source", "reason"})
+
+// ProxiesEvicted 移出轮换的代理数；reason 为 failure / quarantine / removed / banned / drained / expired
+var ProxiesEvicted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_pool_proxies_evicted_total",
+	Help: "Proxies taken out of rotation, by reason.",
+}, []string{"reason"})
+
+// Fetches 拉取来源 API 的次数；result 为 ok / error
+var Fetches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_pool_fetches_total",
+	Help: "Upstream list API fetches, by source and result.",
+}, []string{"source", "result"})
+
+// FetchDuration 拉取来源 API 的耗时
+var FetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "proxy_pool_fetch_duration_seconds",
+	Help:    "Latency of upstream list API fetches.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"source"})
+
+// UpstreamRequests 经上游发起的请求/隧道数；source 为上游所属来源（按来源聚合，避免每个代理一条序列），kind 为 http / connect / socks
+var UpstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_pool_upstream_requests_total",
+	Help: "Requests and tunnels attempted through upstreams, by source.",
+}, []string{"source", "kind"})
+
+// UpstreamErrors 经上游失败的请求/隧道数，按来源聚合
+var UpstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_pool_upstream_errors_total",
+	Help: "Requests and tunnels that failed at the upstream, by source.",
+}, []string{"source", "kind"})
+
+// UpstreamProxyRequests 按单个上游计数的请求/隧道数，仅在 --metrics-per-upstream 时记录；
+// upstream 为去掉密码的上游地址，代理离开池子时删除对应序列，序列数以池子规模为上限
+var UpstreamProxyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_pool_upstream_proxy_requests_total",
+	Help: "Requests and tunnels attempted through each upstream in the pool (--metrics-per-upstream).",
+}, []string{"upstream", "kind"})
+
+// UpstreamProxyErrors 按单个上游计数的失败数，仅在 --metrics-per-upstream 时记录
+var UpstreamProxyErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_pool_upstream_proxy_errors_total",
+	Help: "Requests and tunnels that failed at each upstream in the pool (--metrics-per-upstream).",
+}, []string{"upstream", "kind"})
+
+// perUpstream 是否同时按单个上游计数
+var perUpstream atomic.Bool
+
+// SetPerUpstream 开关按单个上游计数（--metrics-per-upstream）
+func SetPerUpstream(on bool) { perUpstream.Store(on) }
+
+// UpstreamRequest 记录一次经上游 addr 的请求/隧道：按来源计数，开启时同时按上游计数
+func UpstreamRequest(source, addr, kind string) {
+	UpstreamRequests.WithLabelValues(source, kind).Inc()
+	if perUpstream.Load() {
+		UpstreamProxyRequests.WithLabelValues(upstreamLabel(addr), kind).Inc()
+	}
+}
+
+// UpstreamError 记录一次经上游 addr 的失败
+func UpstreamError(source, addr, kind string) {
+	UpstreamErrors.WithLabelValues(source, kind).Inc()
+	if perUpstream.Load() {
+		UpstreamProxyErrors.WithLabelValues(upstreamLabel(addr), kind).Inc()
+	}
+}
+
+// ForgetUpstream 删除 addr 的按上游序列；代理离开池子时由池子调用
+func ForgetUpstream(addr string) {
+	if !perUpstream.Load() {
+		return
+	}
+	l := prometheus.Labels{"upstream": upstreamLabel(addr)}
+	UpstreamProxyRequests.DeletePartialMatch(l)
+	UpstreamProxyErrors.DeletePartialMatch(l)
+}
+
+// upstreamLabel 上游地址去掉密码后的形式；同一网关不同用户名（会话）的代理各占一条序列
+func upstreamLabel(addr string) string {
+	raw := addr
+	if !strings.Contains(addr, "://") {
+		raw = "http://" + addr
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+	return u.Redacted()
+}
+
+// ConnectDuration 经上游建立隧道（含上游握手）的耗时；scheme 为上游协议
+var ConnectDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "proxy_pool_connect_duration_seconds",
+	Help:    "Time to establish a tunnel through an upstream, including its handshake.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"scheme"})
+
+// DirectFallbacks 重试耗尽后直连目标的次数
+var DirectFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_pool_direct_fallbacks_total",
+	Help: "Requests sent directly after no upstream succeeded.",
+}, []string{"kind"})
+
+// ActiveTunnels 当前打开的隧道数；via 为 upstream / direct
+var ActiveTunnels = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "proxy_pool_active_tunnels",
+	Help: "Currently open CONNECT/SOCKS5 tunnels.",
+}, []string{"via"})
+
+// ConfigReloads 配置热加载次数；result 为 ok / rejected
+var ConfigReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_pool_config_reloads_total",
+	Help: "Configuration reloads by result.",
+}, []string{"result"})
+
+// WatchPool 注册池子规模指标，抓取时调用 size / usable 取值；只应调用一次
+func WatchPool(size, usable func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "proxy_pool_size",
+		Help: "Proxies currently in the pool, including quarantined and expiring ones.",
+	}, func() float64 { return float64(size()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "proxy_pool_usable",
+		Help: "Proxies currently eligible for selection.",
+	}, func() float64 { return float64(usable()) })
+}
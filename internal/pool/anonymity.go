@@ -0,0 +1,54 @@
+package pool
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Anonymity 上游的匿名等级，数值越大越匿名
+type Anonymity int
+
+const (
+	AnonymityUnknown     Anonymity = iota // 未检测
+	AnonymityTransparent                  // 目标能看到我们的真实 IP
+	AnonymityAnonymous                    // 隐藏了真实 IP，但带有 Via / X-Forwarded-For 等代理痕迹
+	AnonymityElite                        // 既不泄露真实 IP，也没有代理痕迹
+)
+
+func (a Anonymity) String() string {
+	switch a {
+	case AnonymityTransparent:
+		return "transparent"
+	case AnonymityAnonymous:
+		return "anonymous"
+	case AnonymityElite:
+		return "elite"
+	}
+	return "unknown"
+}
+
+// MarshalText 以等级名称序列化（用于快照）
+func (a Anonymity) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Anonymity) UnmarshalText(b []byte) error {
+	v, err := ParseAnonymity(string(b))
+	*a = v
+	return err
+}
+
+// ParseAnonymity 解析等级名称；空字符串与 "off" 视为 AnonymityUnknown（不限制）
+func ParseAnonymity(s string) (Anonymity, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "off", "unknown":
+		return AnonymityUnknown, nil
+	case "transparent":
+		return AnonymityTransparent, nil
+	case "anonymous":
+		return AnonymityAnonymous, nil
+	case "elite":
+		return AnonymityElite, nil
+	}
+	return AnonymityUnknown, fmt.Errorf("unknown anonymity level %q (supported: transparent, anonymous, elite)", s)
+}
@@ -0,0 +1,83 @@
+package pool
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/metrics"
+)
+
+// Ban 一条封禁记录
+type Ban struct {
+	Addr  string    `json:"addr"`
+	Until time.Time `json:"until,omitzero"` // 解封时间；零值表示永久
+}
+
+// Ban 把地址移出池子并封禁 d（<=0 表示永久），封禁期间 AddFrom 不再接受该地址。
+// 返回该地址此前是否在池中。
+func (p *Pool) Ban(addr string, d time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var until time.Time
+	if d > 0 {
+		until = time.Now().Add(d)
+	}
+	p.bans[addr] = until
+	return p.removeLocked(addr, "banned")
+}
+
+// Unban 解除封禁；返回此前是否处于封禁中
+func (p *Pool) Unban(addr string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	banned := p.bannedLocked(addr, time.Now())
+	delete(p.bans, addr)
+	return banned
+}
+
+// Bans 返回当前有效的封禁，按地址排序
+func (p *Pool) Bans() []Ban {
+	now := time.Now()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]Ban, 0, len(p.bans))
+	for addr, until := range p.bans {
+		if until.IsZero() || now.Before(until) {
+			out = append(out, Ban{Addr: addr, Until: until})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Addr < out[j].Addr })
+	return out
+}
+
+// bannedLocked 地址是否处于封禁中；已到期的记录顺便删除。调用方需持写锁
+func (p *Pool) bannedLocked(addr string, now time.Time) bool {
+	until, ok := p.bans[addr]
+	if !ok {
+		return false
+	}
+	if until.IsZero() || now.Before(until) {
+		return true
+	}
+	delete(p.bans, addr)
+	return false
+}
+
+// Drain 清空池子，返回移除的代理数；在途的请求与隧道不受影响
+func (p *Pool) Drain() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.proxies)
+	if n == 0 {
+		return 0
+	}
+	for i, pr := range p.proxies {
+		metrics.ForgetUpstream(pr.Addr)
+		p.proxies[i] = nil
+	}
+	p.proxies = p.proxies[:0]
+	clear(p.set)
+	metrics.ProxiesEvicted.WithLabelValues("drained").Add(float64(n))
+	p.notifyRemovedLocked()
+	return n
+}
@@ -1,74 +1,448 @@
 package pool
 
 import (
+	"math/rand/v2"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/metrics"
 )
 
 type Proxy struct {
 	Addr     string
 	ExpireAt time.Time
+
+	// 来源信息，首次入池时确定；多个来源返回同一地址时以先到者为准
+	Source       string // 来源名称
+	Tag          string // 来源标签
+	SourceWeight int    // 来源权重（weighted 策略先按来源权重分配，<=0 视为 1）
+
+	// 条目元数据，来自 API 的字段映射
+	Labels         map[string]string // 标签，如 city/isp；只读
+	VendorExpireAt time.Time         // 供应商给出的过期时间；零值表示未提供
+	ExitIP         string            // 入池检测时发现的出口 IP；空表示未知
+	Anonymity      Anonymity         // 入池检测时判定的匿名等级
+
+	// 健康统计
+	Success          uint64    // 累计成功次数
+	Failure          uint64    // 累计失败次数
+	ConsecutiveFails int       // 当前连续失败次数（成功一次即清零）
+	QuarantineUntil  time.Time // 隔离截止时间；在此之前不会被 Get 选中
+
+	// 供选择策略使用
+	Weight      int           // 加权随机的权重（<=0 视为 1）
+	InFlight    atomic.Int64  // 当前在途请求/隧道数，由 Acquire 维护
+	LatencyEWMA time.Duration // 成功请求延迟的指数加权平均；0 表示尚无数据
+
+	fails []time.Time // 滑动窗口内的失败时间点
+}
+
+// ewmaAlpha 新样本在延迟 EWMA 中的权重
+const ewmaAlpha = 0.3
+
+// Options 池子的健康策略
+type Options struct {
+	FailThreshold int           // 窗口内失败达到该次数判定为不健康；<=1 表示首次失败即处理（旧行为）
+	FailWindow    time.Duration // 失败计数的滑动窗口；<=0 表示不限窗口
+	Quarantine    time.Duration // >0 时不健康的代理先隔离该时长；否则直接移除
+	Selector      Selector      // 选择策略；nil 表示轮询
+
+	// 供应商过期时间：条目带 ExpireAt 时取代 TTL，并限制在 [MinTTL, MaxTTL] 内
+	MinTTL       time.Duration // 过期时间至少为入池后该时长，防止时钟偏差/时区错误导致刚入池即过期
+	MaxTTL       time.Duration // 过期时间最多为入池后该时长；<=0 不限
+	ExpiryMargin time.Duration // 距过期不足该时长的代理不再分配给新请求，留给在途的长连接收尾
+
+	ExitIPDedup string // 出口 IP 去重方式：ExitIPKeep（默认）/ ExitIPReject / ExitIPGroup
+
+	MinAnonymity Anonymity // 匿名等级低于该值（含未检测）的代理不参与选择；AnonymityUnknown 表示不限制
 }
 
 type Pool struct {
 	mu      sync.RWMutex
-	proxies []Proxy
-	idx     uint32
-	set     map[string]struct{} // 去重用（记录是否存在）
+	proxies []*Proxy
+	set     map[string]*Proxy    // 去重用（记录是否存在）
+	bans    map[string]time.Time // 被封禁的地址 -> 解封时间；零值表示永久
+	opts    Options
+	added   chan struct{} // 有新代理入池时关闭并替换，用于唤醒等待者
+	removed chan struct{} // 有代理被移除/清理时关闭并替换，用于触发补充
 }
 
-func New() *Pool {
-	return &Pool{set: make(map[string]struct{})}
+func New(opts Options) *Pool {
+	if opts.Selector == nil {
+		opts.Selector = &roundRobin{}
+	}
+	return &Pool{
+		set:     make(map[string]*Proxy),
+		bans:    make(map[string]time.Time),
+		opts:    opts,
+		added:   make(chan struct{}),
+		removed: make(chan struct{}),
+	}
+}
+
+// SetOptions 热更新健康策略、选择策略与准入条件；opts.Selector 为 nil 时沿用当前的选择器。
+// 已在池中的代理不会因新条件被删除，只是不满足时不再参与选择
+func (p *Pool) SetOptions(opts Options) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if opts.Selector == nil {
+		opts.Selector = p.opts.Selector
+	}
+	p.opts = opts
+	p.notifyRemovedLocked() // 条件收紧后可用数可能下降，唤醒低水位补充
+}
+
+// Added 返回一个在下一次新增代理时被关闭的 channel。
+// 应在检查池子之前先取 channel，避免错过两者之间发生的新增。
+func (p *Pool) Added() <-chan struct{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.added
+}
+
+// Origin 代理的来源信息与条目元数据
+type Origin struct {
+	Source       string
+	Tag          string
+	SourceWeight int // 来源权重
+	Weight       int // 代理自身的权重（来源内加权）；<=0 视为 1
+	Labels       map[string]string
+	ExpireAt     time.Time // 供应商给出的过期时间
+	ExitIP       string    // 出口 IP
+	Anonymity    Anonymity
+}
+
+// AddResult AddFrom 的结果
+type AddResult int
+
+const (
+	Added         AddResult = iota // 新入池
+	Renewed                        // 已存在，仅续期
+	Expiring                       // 已临近过期，未入池
+	DuplicateExit                  // 出口 IP 与池中已有代理相同（ExitIPReject），未入池
+	LowAnonymity                   // 匿名等级低于 MinAnonymity，未入池
+	Banned                         // 地址已被封禁，未入池
+	Invalid                        // 地址为空或 ttl 无效
+)
+
+func (r AddResult) String() string {
+	switch r {
+	case Added:
+		return "added"
+	case Renewed:
+		return "renewed"
+	case Expiring:
+		return "expiring"
+	case DuplicateExit:
+		return "duplicate-exit-ip"
+	case LowAnonymity:
+		return "low-anonymity"
+	case Banned:
+		return "banned"
+	}
+	return "invalid"
+}
+
+// 出口 IP 去重方式（--exit-ip-dedup）
+const (
+	ExitIPKeep   = "off"    // 只记录，不去重
+	ExitIPReject = "reject" // 出口 IP 已在池中的新代理不入池
+	ExitIPGroup  = "group"  // 入池，但选择时同一出口 IP 只算一个候选，轮询按 IP 轮换
+)
+
+// Removed 返回一个在下一次有代理被移除（淘汰、删除或过期清理）时被关闭的 channel
+func (p *Pool) Removed() <-chan struct{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.removed
+}
+
+// notifyRemovedLocked 唤醒 Removed 的等待者；调用方需持写锁
+func (p *Pool) notifyRemovedLocked() {
+	close(p.removed)
+	p.removed = make(chan struct{})
 }
 
 // Add 追加一个代理；已存在则按需续期到更晚的过期时间
 func (p *Pool) Add(addr string, ttl time.Duration) {
+	p.AddFrom(addr, ttl, Origin{})
+}
+
+// AddFrom 与 Add 相同，并记录来源信息与元数据。
+// o.ExpireAt 非零时以它（经 MinTTL/MaxTTL 限制）代替 ttl；已临近过期的条目不入池。
+// 地址已存在时只续期，保留原来源；ExitIPReject 下出口 IP 已存在的新代理不入池，
+// 匿名等级低于 MinAnonymity 的新代理不入池。
+func (p *Pool) AddFrom(addr string, ttl time.Duration, o Origin) AddResult {
 	if addr == "" || ttl <= 0 {
-		return
+		return Invalid
 	}
-	exp := time.Now().Add(ttl)
-
-	p.mu.Lock()
+	now := time.Now()
+	p.mu.Lock() // p.opts 可能被 SetOptions 热更新，需在锁内读取
 	defer p.mu.Unlock()
 
+	exp := now.Add(ttl)
+	if !o.ExpireAt.IsZero() {
+		exp = p.clampExpiry(now, o.ExpireAt)
+	}
+	if !exp.After(now.Add(p.opts.ExpiryMargin)) {
+		return Expiring
+	}
+
+	if p.bannedLocked(addr, now) {
+		return Banned
+	}
 	// 已存在：续期
-	if _, ok := p.set[addr]; ok {
-		for i := range p.proxies {
-			if p.proxies[i].Addr == addr {
-				if exp.After(p.proxies[i].ExpireAt) {
-					p.proxies[i].ExpireAt = exp
-				}
-				return
+	if pr, ok := p.set[addr]; ok {
+		if exp.After(pr.ExpireAt) {
+			pr.ExpireAt = exp
+		}
+		if o.ExpireAt.After(pr.VendorExpireAt) {
+			pr.VendorExpireAt = o.ExpireAt
+		}
+		if o.Weight > 0 {
+			pr.Weight = o.Weight
+		}
+		return Renewed
+	}
+	if o.Anonymity < p.opts.MinAnonymity {
+		return LowAnonymity
+	}
+	if o.ExitIP != "" && p.opts.ExitIPDedup == ExitIPReject {
+		for _, pr := range p.proxies {
+			if pr.ExitIP == o.ExitIP && now.Before(pr.ExpireAt) {
+				return DuplicateExit
 			}
 		}
-		// 理论上不会走到这里（set 有但切片里没找到），
-		// 但为健壮性起见，继续走新增逻辑。
 	}
 
 	// 新增
-	p.proxies = append(p.proxies, Proxy{Addr: addr, ExpireAt: exp})
-	p.set[addr] = struct{}{}
+	pr := &Proxy{
+		Addr: addr, ExpireAt: exp,
+		Source: o.Source, Tag: o.Tag, SourceWeight: o.SourceWeight, Weight: o.Weight,
+		Labels: o.Labels, VendorExpireAt: o.ExpireAt, ExitIP: o.ExitIP, Anonymity: o.Anonymity,
+	}
+	p.proxies = append(p.proxies, pr)
+	p.set[addr] = pr
+	metrics.ProxiesAdded.WithLabelValues(o.Source).Inc()
+	close(p.added)
+	p.added = make(chan struct{})
+	return Added
+}
+
+// clampExpiry 把供应商过期时间限制在 [now+MinTTL, now+MaxTTL] 内；调用方需持锁
+func (p *Pool) clampExpiry(now, exp time.Time) time.Time {
+	if lo := now.Add(p.opts.MinTTL); p.opts.MinTTL > 0 && exp.Before(lo) {
+		return lo
+	}
+	if hi := now.Add(p.opts.MaxTTL); p.opts.MaxTTL > 0 && exp.After(hi) {
+		return hi
+	}
+	return exp
 }
 
-// Get 轮询获取一个未过期的代理；会跳过过期项（不在此处删除，交给 Sweep/Remove）
+// usable 未临近过期、未被隔离且匿名等级达标；调用方需持锁
+func (p *Pool) usable(pr *Proxy, now time.Time) bool {
+	return now.Add(p.opts.ExpiryMargin).Before(pr.ExpireAt) && !now.Before(pr.QuarantineUntil) &&
+		pr.Anonymity >= p.opts.MinAnonymity
+}
+
+// Contains 地址是否在池中（含已过期但尚未清理的）
+func (p *Pool) Contains(addr string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.set[addr]
+	return ok
+}
+
+// OriginOf 返回池中代理的来源信息
+func (p *Pool) OriginOf(addr string) (Origin, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	pr, ok := p.set[addr]
+	if !ok {
+		return Origin{}, false
+	}
+	return Origin{Source: pr.Source, Tag: pr.Tag, SourceWeight: pr.SourceWeight, Weight: pr.Weight, Labels: pr.Labels, ExpireAt: pr.VendorExpireAt, ExitIP: pr.ExitIP, Anonymity: pr.Anonymity}, true
+}
+
+// Filter 候选过滤函数，返回 false 的代理不参与选择。
+// 在池子读锁下调用：只读访问 *Proxy，且不得回调 Pool 的方法。
+type Filter func(pr *Proxy) bool
+
+// MatchLabels 返回按标签筛选的过滤器：want 中每一项都须满足，值可用 "|" 分隔多个候选，不区分大小写。
+// 代理没有该标签时，"source" 与 "tag" 分别匹配来源名称与来源标签。
+func MatchLabels(want map[string]string) Filter {
+	return func(pr *Proxy) bool {
+		for k, v := range want {
+			got, ok := pr.Labels[k]
+			if !ok {
+				switch k {
+				case "source":
+					got = pr.Source
+				case "tag":
+					got = pr.Tag
+				}
+			}
+			if !matchAny(got, v) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func matchAny(got, want string) bool {
+	if got == "" {
+		return false
+	}
+	for _, w := range strings.Split(want, "|") {
+		if strings.EqualFold(got, strings.TrimSpace(w)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get 按选择策略获取一个未过期的代理；会跳过过期/临近过期项与隔离中的代理（不在此处删除，交给 Sweep/Remove）
 func (p *Pool) Get() (string, bool) {
+	return p.Pick(nil)
+}
+
+// Pick 与 Get 相同，但只在满足 f 的代理中选择；f 为 nil 表示不过滤
+func (p *Pool) Pick(f Filter) (string, bool) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	n := len(p.proxies)
-	if n == 0 {
+	if len(p.proxies) == 0 {
 		return "", false
 	}
 	now := time.Now()
-	for tries := 0; tries < n; tries++ {
-		i := int(atomic.AddUint32(&p.idx, 1)) % n
-		pr := p.proxies[i]
-		if now.Before(pr.ExpireAt) {
-			return pr.Addr, true
+	cands := make([]*Proxy, 0, len(p.proxies))
+	for _, pr := range p.proxies {
+		if p.usable(pr, now) && (f == nil || f(pr)) {
+			cands = append(cands, pr)
+		}
+	}
+	if len(cands) == 0 {
+		return "", false
+	}
+	if p.opts.ExitIPDedup == ExitIPGroup {
+		cands = groupByExitIP(cands)
+	}
+	return p.opts.Selector.Select(cands).Addr, true
+}
+
+// groupByExitIP 同一出口 IP 只保留一个随机成员，使选择策略在出口 IP 之间轮换；出口未知的各自成组
+func groupByExitIP(cands []*Proxy) []*Proxy {
+	out := cands[:0:0]
+	idx := make(map[string]int)
+	seen := make(map[string]int) // 出口 IP -> 已见成员数，用于蓄水池抽样
+	for _, pr := range cands {
+		if pr.ExitIP == "" {
+			out = append(out, pr)
+			continue
+		}
+		i, ok := idx[pr.ExitIP]
+		if !ok {
+			idx[pr.ExitIP] = len(out)
+			seen[pr.ExitIP] = 1
+			out = append(out, pr)
+			continue
+		}
+		seen[pr.ExitIP]++
+		if rand.IntN(seen[pr.ExitIP]) == 0 {
+			out[i] = pr
 		}
 	}
-	return "", false
+	return out
+}
+
+// Available 判断 addr 是否仍在池中、未临近过期、未被隔离且满足 f（nil 表示不过滤）
+func (p *Pool) Available(addr string, f Filter) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	pr, ok := p.set[addr]
+	if !ok {
+		return false
+	}
+	now := time.Now()
+	return p.usable(pr, now) && (f == nil || f(pr))
+}
+
+// Acquire 标记一次在途使用，返回的 release 必须恰好调用一次。
+// 代理不在池中时返回空操作。
+func (p *Pool) Acquire(addr string) (release func()) {
+	p.mu.RLock()
+	pr, ok := p.set[addr]
+	p.mu.RUnlock()
+	if !ok {
+		return func() {}
+	}
+	pr.InFlight.Add(1)
+	return func() { pr.InFlight.Add(-1) }
+}
+
+// ReportSuccess 记录一次成功：累计计数 +1，清零连续失败，并把 latency 计入 EWMA（<=0 忽略）
+func (p *Pool) ReportSuccess(addr string, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pr, ok := p.set[addr]
+	if !ok {
+		return
+	}
+	pr.Success++
+	pr.ConsecutiveFails = 0
+	if latency > 0 {
+		if pr.LatencyEWMA == 0 {
+			pr.LatencyEWMA = latency
+		} else {
+			pr.LatencyEWMA = time.Duration(ewmaAlpha*float64(latency) + (1-ewmaAlpha)*float64(pr.LatencyEWMA))
+		}
+	}
+}
+
+// ReportFailure 记录一次失败；窗口内失败次数达到阈值时隔离或移除该代理。
+// 返回值 evicted 表示本次失败是否导致该代理被隔离/移除。
+func (p *Pool) ReportFailure(addr string) (evicted bool) {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pr, ok := p.set[addr]
+	if !ok {
+		return false
+	}
+	pr.Failure++
+	pr.ConsecutiveFails++
+
+	// 丢弃窗口外的失败记录
+	if p.opts.FailWindow > 0 {
+		cut := now.Add(-p.opts.FailWindow)
+		keep := pr.fails[:0]
+		for _, t := range pr.fails {
+			if t.After(cut) {
+				keep = append(keep, t)
+			}
+		}
+		pr.fails = keep
+	}
+	pr.fails = append(pr.fails, now)
+
+	if len(pr.fails) < p.opts.FailThreshold {
+		return false
+	}
+	pr.fails = nil
+	if p.opts.Quarantine > 0 {
+		pr.QuarantineUntil = now.Add(p.opts.Quarantine)
+		metrics.ProxiesEvicted.WithLabelValues("quarantine").Inc()
+		return true
+	}
+	p.removeLocked(addr, "failure")
+	return true
 }
 
 // Remove 从池中移除一个代理；返回是否真的移除了（存在即删）
@@ -79,24 +453,25 @@ func (p *Pool) Remove(addr string) bool {
 	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	return p.removeLocked(addr, "removed")
+}
 
+// removeLocked 调用方需持有写锁；reason 用于指标
+func (p *Pool) removeLocked(addr, reason string) bool {
 	if _, ok := p.set[addr]; !ok {
 		return false
 	}
+	defer p.notifyRemovedLocked()
+	metrics.ProxiesEvicted.WithLabelValues(reason).Inc()
+	metrics.ForgetUpstream(addr)
 	// 在线性表中找到并删除；保持顺序（稳定删除）
 	for i := range p.proxies {
 		if p.proxies[i].Addr == addr {
 			// 删除切片元素 i
 			copy(p.proxies[i:], p.proxies[i+1:])
+			p.proxies[len(p.proxies)-1] = nil
 			p.proxies = p.proxies[:len(p.proxies)-1]
 			delete(p.set, addr)
-
-			// 调整 idx，避免越界（可选，属于健壮性处理）
-			if len(p.proxies) == 0 {
-				p.idx = 0
-			} else {
-				p.idx %= uint32(len(p.proxies))
-			}
 			return true
 		}
 	}
@@ -110,27 +485,34 @@ func (p *Pool) Delete(addr string) bool {
 	return p.Remove(addr)
 }
 
-// Sweep 清理已过期项
+// Sweep 清理已过期项与到期的封禁
 func (p *Pool) Sweep() {
 	now := time.Now()
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	for addr := range p.bans {
+		p.bannedLocked(addr, now)
+	}
+
 	dst := p.proxies[:0]
 	for _, pr := range p.proxies {
 		if now.Before(pr.ExpireAt) {
 			dst = append(dst, pr)
 		} else {
 			delete(p.set, pr.Addr)
+			metrics.ForgetUpstream(pr.Addr)
 		}
 	}
-	p.proxies = dst
-	// idx 修正（防止越界）
-	if len(p.proxies) == 0 {
-		p.idx = 0
-	} else {
-		p.idx %= uint32(len(p.proxies))
+	if len(dst) == len(p.proxies) {
+		return
+	}
+	metrics.ProxiesEvicted.WithLabelValues("expired").Add(float64(len(p.proxies) - len(dst)))
+	for i := len(dst); i < len(p.proxies); i++ {
+		p.proxies[i] = nil
 	}
+	p.proxies = dst
+	p.notifyRemovedLocked()
 }
 
 func (p *Pool) Size() int {
@@ -138,3 +520,76 @@ func (p *Pool) Size() int {
 	defer p.mu.RUnlock()
 	return len(p.proxies)
 }
+
+// Info 代理状态的只读快照
+type Info struct {
+	Addr             string
+	ExpireAt         time.Time
+	Source           string
+	Tag              string
+	Labels           map[string]string
+	Weight           int
+	VendorExpireAt   time.Time
+	ExitIP           string
+	Anonymity        Anonymity
+	Success          uint64
+	Failure          uint64
+	ConsecutiveFails int
+	QuarantineUntil  time.Time
+	InFlight         int64
+	LatencyEWMA      time.Duration
+}
+
+// List 返回池中所有代理的快照，顺序与入池顺序一致
+func (p *Pool) List() []Info {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]Info, 0, len(p.proxies))
+	for _, pr := range p.proxies {
+		out = append(out, Info{
+			Addr:             pr.Addr,
+			ExpireAt:         pr.ExpireAt,
+			Source:           pr.Source,
+			Tag:              pr.Tag,
+			Labels:           pr.Labels,
+			Weight:           pr.Weight,
+			VendorExpireAt:   pr.VendorExpireAt,
+			ExitIP:           pr.ExitIP,
+			Anonymity:        pr.Anonymity,
+			Success:          pr.Success,
+			Failure:          pr.Failure,
+			ConsecutiveFails: pr.ConsecutiveFails,
+			QuarantineUntil:  pr.QuarantineUntil,
+			InFlight:         pr.InFlight.Load(),
+			LatencyEWMA:      pr.LatencyEWMA,
+		})
+	}
+	return out
+}
+
+// Unquarantine 提前解除隔离（如主动检测已恢复）；返回该代理此前是否处于隔离中
+func (p *Pool) Unquarantine(addr string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr, ok := p.set[addr]
+	if !ok || !time.Now().Before(pr.QuarantineUntil) {
+		return false
+	}
+	pr.QuarantineUntil = time.Time{}
+	pr.fails = nil
+	return true
+}
+
+// Usable 当前可分配的代理数：未临近过期且未被隔离
+func (p *Pool) Usable() int {
+	now := time.Now()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	n := 0
+	for _, pr := range p.proxies {
+		if p.usable(pr, now) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,141 @@
+package pool
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// AddFrom 与 SetOptions 并发（热加载期间追加仍在进行）；需配合 -race 运行
+func TestAddFromConcurrentWithSetOptions(t *testing.T) {
+	p := New(Options{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			o := Origin{Source: "a", ExpireAt: time.Now().Add(time.Hour)}
+			p.AddFrom(fmt.Sprintf("127.0.0.1:%d", 10000+i), time.Minute, o)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			p.SetOptions(Options{
+				MinTTL:        time.Duration(i) * time.Millisecond,
+				MaxTTL:        time.Hour,
+				ExpiryMargin:  time.Duration(i%5) * time.Second,
+				FailThreshold: 3,
+			})
+		}
+	}()
+	wg.Wait()
+	if p.Size() != 500 {
+		t.Fatalf("size = %d, want 500", p.Size())
+	}
+}
+
+func TestAddFromExpiry(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		opts     Options
+		ttl      time.Duration
+		expireAt time.Time
+		want     AddResult
+	}{
+		{"ttl", Options{}, time.Minute, time.Time{}, Added},
+		{"zero ttl", Options{}, 0, time.Time{}, Invalid},
+		{"vendor expiry", Options{}, time.Minute, now.Add(time.Hour), Added},
+		{"vendor expired", Options{}, time.Minute, now.Add(-time.Minute), Expiring},
+		{"raised by min-ttl", Options{MinTTL: time.Minute}, time.Minute, now.Add(-time.Minute), Added},
+		{"inside margin", Options{ExpiryMargin: time.Minute}, time.Minute, now.Add(30 * time.Second), Expiring},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(tt.opts)
+			if got := p.AddFrom("127.0.0.1:8080", tt.ttl, Origin{ExpireAt: tt.expireAt}); got != tt.want {
+				t.Fatalf("AddFrom = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddFromWeight(t *testing.T) {
+	p := New(Options{})
+	p.AddFrom("127.0.0.1:8080", time.Minute, Origin{Source: "a", SourceWeight: 3, Weight: 5})
+	o, ok := p.OriginOf("127.0.0.1:8080")
+	if !ok || o.SourceWeight != 3 || o.Weight != 5 {
+		t.Fatalf("OriginOf = %+v %v, want source weight 3, weight 5", o, ok)
+	}
+	// 续期时采用供应商最新给出的权重；未给出则保留原值
+	p.AddFrom("127.0.0.1:8080", time.Minute, Origin{Source: "a", Weight: 2})
+	p.AddFrom("127.0.0.1:8080", time.Minute, Origin{Source: "a"})
+	if got := p.List()[0].Weight; got != 2 {
+		t.Fatalf("weight after renew = %d, want 2", got)
+	}
+}
+
+func TestReportFailure(t *testing.T) {
+	const addr = "127.0.0.1:8080"
+	tests := []struct {
+		name       string
+		opts       Options
+		fails      int
+		sleep      time.Duration // 最后一次失败前的等待（用于滑出窗口）
+		evicted    bool
+		inPool     bool
+		quarantine bool
+	}{
+		{"first failure evicts by default", Options{}, 1, 0, true, false, false},
+		{"below threshold", Options{FailThreshold: 3}, 2, 0, false, true, false},
+		{"threshold reached", Options{FailThreshold: 3}, 3, 0, true, false, false},
+		{"old failures leave the window", Options{FailThreshold: 2, FailWindow: 50 * time.Millisecond}, 2, 80 * time.Millisecond, false, true, false},
+		{"failures inside the window", Options{FailThreshold: 2, FailWindow: time.Minute}, 2, 0, true, false, false},
+		{"quarantine instead of removal", Options{FailThreshold: 2, Quarantine: time.Minute}, 2, 0, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(tt.opts)
+			p.AddFrom(addr, time.Minute, Origin{Source: "a"})
+			var evicted bool
+			for i := 0; i < tt.fails; i++ {
+				if i == tt.fails-1 {
+					time.Sleep(tt.sleep)
+				}
+				evicted = p.ReportFailure(addr)
+			}
+			if evicted != tt.evicted {
+				t.Fatalf("evicted = %v, want %v", evicted, tt.evicted)
+			}
+			if got := p.Size() == 1; got != tt.inPool {
+				t.Fatalf("in pool = %v, want %v", got, tt.inPool)
+			}
+			if !tt.inPool {
+				return
+			}
+			// 隔离中的代理留在池中但不可用
+			if usable := p.Usable() == 1; usable == tt.quarantine {
+				t.Fatalf("usable = %v, quarantined = %v", usable, tt.quarantine)
+			}
+			if _, ok := p.Get(); ok == tt.quarantine {
+				t.Fatalf("Get ok = %v, quarantined = %v", ok, tt.quarantine)
+			}
+		})
+	}
+}
+
+// 成功会清零连续失败，但不清空窗口内的失败记录
+func TestReportSuccessKeepsWindow(t *testing.T) {
+	p := New(Options{FailThreshold: 2, FailWindow: time.Minute})
+	p.AddFrom("127.0.0.1:8080", time.Minute, Origin{})
+	p.ReportFailure("127.0.0.1:8080")
+	p.ReportSuccess("127.0.0.1:8080", 10*time.Millisecond)
+	if in := p.List()[0]; in.ConsecutiveFails != 0 || in.Success != 1 {
+		t.Fatalf("after success: %+v", in)
+	}
+	if !p.ReportFailure("127.0.0.1:8080") {
+		t.Fatal("second failure inside the window should evict")
+	}
+}
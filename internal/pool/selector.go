@@ -0,0 +1,183 @@
+package pool
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync/atomic"
+)
+
+// Selector 从可用候选中挑选一个上游。
+// cands 由 Pool 在读锁下传入，保证非空；实现方只读、不得保留引用。
+type Selector interface {
+	Select(cands []*Proxy) *Proxy
+}
+
+// 内置策略名称（--strategy）
+const (
+	StrategyRoundRobin    = "round-robin"
+	StrategyRandom        = "random"
+	StrategyWeighted      = "weighted"
+	StrategyLeastInFlight = "least-inflight"
+	StrategyEWMA          = "ewma"
+	StrategyP2C           = "p2c"
+)
+
+// Strategies 列出所有内置策略名称
+var Strategies = []string{StrategyRoundRobin, StrategyRandom, StrategyWeighted, StrategyLeastInFlight, StrategyEWMA, StrategyP2C}
+
+// NewSelector 按名称创建内置策略；空字符串等价于 round-robin
+func NewSelector(name string) (Selector, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", StrategyRoundRobin:
+		return &roundRobin{}, nil
+	case StrategyRandom:
+		return randomSelector{}, nil
+	case StrategyWeighted:
+		return weightedSelector{}, nil
+	case StrategyLeastInFlight:
+		return leastInFlight{}, nil
+	case StrategyEWMA:
+		return lowestEWMA{}, nil
+	case StrategyP2C:
+		return powerOfTwo{}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q (supported: %s)", name, strings.Join(Strategies, ", "))
+}
+
+// roundRobin 原子计数轮询
+type roundRobin struct{ idx atomic.Uint32 }
+
+func (s *roundRobin) Select(cands []*Proxy) *Proxy {
+	return cands[s.idx.Add(1)%uint32(len(cands))]
+}
+
+// randomSelector 均匀随机
+type randomSelector struct{}
+
+func (randomSelector) Select(cands []*Proxy) *Proxy {
+	return cands[rand.IntN(len(cands))]
+}
+
+// weightedSelector 两级加权随机：先按来源权重（SourceWeight）选来源，使各来源的流量占比与代理数量无关；
+// 再在该来源内按 Proxy.Weight 选代理。权重 <=0 视为 1
+type weightedSelector struct{}
+
+func (weightedSelector) Select(cands []*Proxy) *Proxy {
+	src := cands[0].Source
+	if !singleSource(cands) {
+		weights := make(map[string]int)
+		total := 0
+		for _, pr := range cands {
+			if _, ok := weights[pr.Source]; !ok {
+				w := positive(pr.SourceWeight)
+				weights[pr.Source] = w
+				total += w
+			}
+		}
+		n := rand.IntN(total)
+		for _, pr := range cands {
+			w, ok := weights[pr.Source]
+			if !ok {
+				continue
+			}
+			delete(weights, pr.Source) // 每个来源只计一次
+			if n -= w; n < 0 {
+				src = pr.Source
+				break
+			}
+		}
+	}
+
+	total := 0
+	for _, pr := range cands {
+		if pr.Source == src {
+			total += positive(pr.Weight)
+		}
+	}
+	n := rand.IntN(total)
+	var last *Proxy
+	for _, pr := range cands {
+		if pr.Source != src {
+			continue
+		}
+		last = pr
+		if n -= positive(pr.Weight); n < 0 {
+			return pr
+		}
+	}
+	return last
+}
+
+func singleSource(cands []*Proxy) bool {
+	for _, pr := range cands[1:] {
+		if pr.Source != cands[0].Source {
+			return false
+		}
+	}
+	return true
+}
+
+func positive(w int) int {
+	if w <= 0 {
+		return 1
+	}
+	return w
+}
+
+// leastInFlight 选当前在途请求最少的；并列项等概率随机选一个
+type leastInFlight struct{}
+
+func (leastInFlight) Select(cands []*Proxy) *Proxy {
+	return pickMin(cands, func(pr *Proxy) int64 { return pr.InFlight.Load() })
+}
+
+// lowestEWMA 选平均延迟最低的；尚无延迟数据（0）的优先，便于新上游获得探测机会
+type lowestEWMA struct{}
+
+func (lowestEWMA) Select(cands []*Proxy) *Proxy {
+	return pickMin(cands, func(pr *Proxy) int64 { return int64(pr.LatencyEWMA) })
+}
+
+// pickMin 选 key 最小的候选；并列时用蓄水池抽样，每个并列项被选中的概率相同
+// （从随机位置开始扫描取第一个最小值的做法会偏向排在较大值之后的那个）
+func pickMin(cands []*Proxy, key func(*Proxy) int64) *Proxy {
+	best, lo, ties := cands[0], key(cands[0]), 1
+	for _, pr := range cands[1:] {
+		switch k := key(pr); {
+		case k < lo:
+			best, lo, ties = pr, k, 1
+		case k == lo:
+			if ties++; rand.IntN(ties) == 0 {
+				best = pr
+			}
+		}
+	}
+	return best
+}
+
+// powerOfTwo 随机取两个，选在途更少者；在途相同再比较延迟
+type powerOfTwo struct{}
+
+func (powerOfTwo) Select(cands []*Proxy) *Proxy {
+	n := len(cands)
+	if n == 1 {
+		return cands[0]
+	}
+	i := rand.IntN(n)
+	j := rand.IntN(n - 1)
+	if j >= i {
+		j++
+	}
+	a, b := cands[i], cands[j]
+	fa, fb := a.InFlight.Load(), b.InFlight.Load()
+	switch {
+	case fa < fb:
+		return a
+	case fb < fa:
+		return b
+	case b.LatencyEWMA < a.LatencyEWMA:
+		return b
+	}
+	return a
+}
@@ -0,0 +1,168 @@
+package pool
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func proxies(addrs ...string) []*Proxy {
+	out := make([]*Proxy, len(addrs))
+	for i, a := range addrs {
+		out[i] = &Proxy{Addr: a}
+	}
+	return out
+}
+
+// counts 用 sel 选 n 次，返回每个地址被选中的次数
+func counts(sel Selector, cands []*Proxy, n int) map[string]int {
+	m := make(map[string]int)
+	for i := 0; i < n; i++ {
+		m[sel.Select(cands).Addr]++
+	}
+	return m
+}
+
+func TestRoundRobin(t *testing.T) {
+	cands := proxies("a", "b", "c")
+	rr := &roundRobin{}
+	var got string
+	for i := 0; i < 6; i++ {
+		got += rr.Select(cands).Addr
+	}
+	if got != "bcabca" {
+		t.Fatalf("order = %s, want bcabca", got)
+	}
+
+	// 计数器回绕时不越界
+	rr.idx.Store(math.MaxUint32 - 2)
+	for i := 0; i < 6; i++ {
+		if pr := rr.Select(cands); pr == nil {
+			t.Fatal("nil selection around counter wrap")
+		}
+	}
+}
+
+// 各来源的流量占比只取决于来源权重，与来源内代理的数量无关
+func TestWeightedSourceShare(t *testing.T) {
+	const n = 40000
+	tests := []struct {
+		name  string
+		cands []*Proxy
+		want  map[string]float64 // 来源 -> 期望占比
+	}{
+		{"equal weights", weightedCands(map[string][2]int{"a": {1, 1}, "b": {1, 9}}), map[string]float64{"a": 0.5, "b": 0.5}},
+		{"3:1 weights", weightedCands(map[string][2]int{"a": {3, 1}, "b": {1, 10}}), map[string]float64{"a": 0.75, "b": 0.25}},
+		{"unset weights", weightedCands(map[string][2]int{"a": {0, 2}, "b": {-1, 6}}), map[string]float64{"a": 0.5, "b": 0.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			share := make(map[string]int)
+			byAddr := make(map[string]string)
+			for _, pr := range tt.cands {
+				byAddr[pr.Addr] = pr.Source
+			}
+			for addr, c := range counts(weightedSelector{}, tt.cands, n) {
+				share[byAddr[addr]] += c
+			}
+			for src, want := range tt.want {
+				if got := float64(share[src]) / n; math.Abs(got-want) > 0.02 {
+					t.Errorf("source %s share = %.3f, want %.2f", src, got, want)
+				}
+			}
+		})
+	}
+}
+
+// weightedCands 按 来源 -> {来源权重, 代理数} 生成候选
+func weightedCands(spec map[string][2]int) []*Proxy {
+	var out []*Proxy
+	for src, s := range spec {
+		for i := 0; i < s[1]; i++ {
+			out = append(out, &Proxy{Addr: src + string(rune('0'+i)), Source: src, SourceWeight: s[0]})
+		}
+	}
+	return out
+}
+
+// 来源内按代理权重分配
+func TestWeightedProxyShare(t *testing.T) {
+	const n = 40000
+	cands := []*Proxy{{Addr: "a", Source: "s", Weight: 3}, {Addr: "b", Source: "s", Weight: 1}}
+	c := counts(weightedSelector{}, cands, n)
+	if got := float64(c["a"]) / n; math.Abs(got-0.75) > 0.02 {
+		t.Fatalf("weight-3 proxy share = %.3f, want 0.75", got)
+	}
+}
+
+func TestLeastInFlight(t *testing.T) {
+	cands := proxies("busy", "idle1", "idle2", "busier")
+	cands[0].InFlight.Store(2)
+	cands[3].InFlight.Store(5)
+	c := counts(leastInFlight{}, cands, 2000)
+	if c["busy"] != 0 || c["busier"] != 0 {
+		t.Fatalf("picked a busier upstream: %v", c)
+	}
+	// 并列项等概率，与在候选中的位置无关
+	if c["idle1"] < 850 || c["idle2"] < 850 {
+		t.Fatalf("ties not spread: %v", c)
+	}
+}
+
+func TestLowestEWMA(t *testing.T) {
+	cands := proxies("slow", "new1", "fast", "new2")
+	cands[0].LatencyEWMA = 50 * time.Millisecond
+	cands[2].LatencyEWMA = 5 * time.Millisecond
+	c := counts(lowestEWMA{}, cands, 2000)
+	if c["slow"] != 0 || c["fast"] != 0 || c["new1"] < 850 || c["new2"] < 850 {
+		t.Fatalf("picks = %v, want only the two without latency data, evenly", c)
+	}
+}
+
+func TestPowerOfTwo(t *testing.T) {
+	if pr := (powerOfTwo{}).Select(proxies("only")); pr.Addr != "only" {
+		t.Fatalf("single candidate = %s", pr.Addr)
+	}
+
+	tests := []struct {
+		name     string
+		setup    func(a, b *Proxy)
+		want     string // 总是选中的地址；空表示两者都应被选中
+		inflight bool
+	}{
+		{"fewer in flight", func(a, b *Proxy) { a.InFlight.Store(1) }, "b", true},
+		{"tie broken by latency", func(a, b *Proxy) {
+			a.InFlight.Store(1)
+			b.InFlight.Store(1)
+			a.LatencyEWMA, b.LatencyEWMA = 10*time.Millisecond, 5*time.Millisecond
+		}, "b", true},
+		{"in flight before latency", func(a, b *Proxy) {
+			a.LatencyEWMA, b.LatencyEWMA = time.Millisecond, time.Second
+			a.InFlight.Store(3)
+		}, "b", true},
+		{"full tie", func(a, b *Proxy) {}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cands := proxies("a", "b")
+			tt.setup(cands[0], cands[1])
+			c := counts(powerOfTwo{}, cands, 2000)
+			if tt.want != "" {
+				if c[tt.want] != 2000 {
+					t.Fatalf("picks = %v, want always %s", c, tt.want)
+				}
+				return
+			}
+			if c["a"] < 600 || c["b"] < 600 {
+				t.Fatalf("ties not spread: %v", c)
+			}
+		})
+	}
+
+	// 在途最多的那个永远不会被选中：两个随机候选互不相同
+	cands := proxies("a", "b", "c")
+	cands[2].InFlight.Store(9)
+	if c := counts(powerOfTwo{}, cands, 2000); c["c"] != 0 {
+		t.Fatalf("picked the busiest upstream: %v", c)
+	}
+}
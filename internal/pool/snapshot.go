@@ -0,0 +1,172 @@
+package pool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotVersion 快照格式版本；格式不兼容地变化时递增
+const snapshotVersion = 1
+
+// Snapshot 池子的持久化内容：代理（含健康统计）与封禁
+type Snapshot struct {
+	Version int           `json:"version"`
+	SavedAt time.Time     `json:"saved_at"`
+	Proxies []ProxyRecord `json:"proxies"`
+	Bans    []Ban         `json:"bans"`
+}
+
+// ProxyRecord 快照中的一个代理；在途计数与失败窗口不保存
+type ProxyRecord struct {
+	Addr             string            `json:"addr"`
+	ExpireAt         time.Time         `json:"expire_at"`
+	Source           string            `json:"source,omitempty"`
+	Tag              string            `json:"tag,omitempty"`
+	SourceWeight     int               `json:"source_weight,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	VendorExpireAt   time.Time         `json:"vendor_expire_at,omitzero"`
+	ExitIP           string            `json:"exit_ip,omitempty"`
+	Anonymity        Anonymity         `json:"anonymity"`
+	Weight           int               `json:"weight,omitempty"`
+	Success          uint64            `json:"success"`
+	Failure          uint64            `json:"failure"`
+	ConsecutiveFails int               `json:"consecutive_fails,omitempty"`
+	QuarantineUntil  time.Time         `json:"quarantine_until,omitzero"`
+	LatencyEWMA      time.Duration     `json:"latency_ewma_ns,omitempty"`
+}
+
+// Snapshot 返回池中所有代理与当前有效的封禁
+func (p *Pool) Snapshot() Snapshot {
+	bans := p.Bans()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	s := Snapshot{Version: snapshotVersion, SavedAt: time.Now(), Proxies: make([]ProxyRecord, 0, len(p.proxies)), Bans: bans}
+	for _, pr := range p.proxies {
+		s.Proxies = append(s.Proxies, ProxyRecord{
+			Addr:             pr.Addr,
+			ExpireAt:         pr.ExpireAt,
+			Source:           pr.Source,
+			Tag:              pr.Tag,
+			SourceWeight:     pr.SourceWeight,
+			Labels:           pr.Labels,
+			VendorExpireAt:   pr.VendorExpireAt,
+			ExitIP:           pr.ExitIP,
+			Anonymity:        pr.Anonymity,
+			Weight:           pr.Weight,
+			Success:          pr.Success,
+			Failure:          pr.Failure,
+			ConsecutiveFails: pr.ConsecutiveFails,
+			QuarantineUntil:  pr.QuarantineUntil,
+			LatencyEWMA:      pr.LatencyEWMA,
+		})
+	}
+	return s
+}
+
+// Restore 载入快照：恢复未到期的封禁，以及未过期、未封禁、匿名等级达标且不在池中的代理。
+// 返回恢复的代理数。
+func (p *Pool) Restore(s Snapshot) (int, error) {
+	if s.Version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, b := range s.Bans {
+		if b.Until.IsZero() || now.Before(b.Until) {
+			p.bans[b.Addr] = b.Until
+		}
+	}
+	n := 0
+	for _, r := range s.Proxies {
+		if r.Addr == "" || !now.Before(r.ExpireAt) || r.Anonymity < p.opts.MinAnonymity {
+			continue
+		}
+		if _, ok := p.set[r.Addr]; ok || p.bannedLocked(r.Addr, now) {
+			continue
+		}
+		pr := &Proxy{
+			Addr:             r.Addr,
+			ExpireAt:         r.ExpireAt,
+			Source:           r.Source,
+			Tag:              r.Tag,
+			SourceWeight:     r.SourceWeight,
+			Labels:           r.Labels,
+			VendorExpireAt:   r.VendorExpireAt,
+			ExitIP:           r.ExitIP,
+			Anonymity:        r.Anonymity,
+			Weight:           r.Weight,
+			Success:          r.Success,
+			Failure:          r.Failure,
+			ConsecutiveFails: r.ConsecutiveFails,
+			QuarantineUntil:  r.QuarantineUntil,
+			LatencyEWMA:      r.LatencyEWMA,
+		}
+		p.proxies = append(p.proxies, pr)
+		p.set[r.Addr] = pr
+		n++
+	}
+	if n > 0 {
+		close(p.added)
+		p.added = make(chan struct{})
+	}
+	return n, nil
+}
+
+// SaveFile 把快照写入 path：先写同目录下的临时文件并 fsync，再原子地 rename，
+// 进程在任何时刻崩溃都只会留下旧文件或新文件，不会留下写了一半的文件
+func (p *Pool) SaveFile(path string) error {
+	data, err := json.MarshalIndent(p.Snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // rename 成功后为空操作
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// rename 本身也要落盘；部分平台不支持对目录 fsync，忽略错误
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
+
+// LoadFile 从 path 读取快照并 Restore；文件不存在时返回 0, nil
+func (p *Pool) LoadFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return 0, fmt.Errorf("decode snapshot %s: %w", path, err)
+	}
+	return p.Restore(s)
+}
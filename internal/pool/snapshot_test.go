@@ -0,0 +1,135 @@
+package pool
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pool.json")
+
+	src := New(Options{})
+	src.AddFrom("http://a:1", time.Hour, Origin{Source: "s1", Tag: "t", Labels: map[string]string{"country": "us"}, ExitIP: "203.0.113.1", Anonymity: AnonymityElite})
+	src.AddFrom("http://low:1", time.Hour, Origin{Source: "s1", Anonymity: AnonymityAnonymous})
+	src.AddFrom("http://short:1", 50*time.Millisecond, Origin{Source: "s2", Anonymity: AnonymityElite})
+	src.AddFrom("http://banned:1", time.Hour, Origin{Source: "s2", Anonymity: AnonymityElite})
+	src.ReportSuccess("http://a:1", 20*time.Millisecond)
+	src.ReportSuccess("http://a:1", 20*time.Millisecond)
+	src.Ban("http://banned:1", 0)
+	src.Ban("http://brief:1", 50*time.Millisecond)
+
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	// 临时文件已 rename 覆盖旧文件，目录里不留 .tmp-*
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "pool.json" {
+		t.Fatalf("files after save = %v, want only pool.json", entries)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatalf("saved file is not a snapshot: %v", err)
+	}
+	if s.Version != snapshotVersion || len(s.Proxies) != 3 || len(s.Bans) != 2 {
+		t.Fatalf("saved version %d, %d proxies, %d bans; want %d, 3, 2", s.Version, len(s.Proxies), len(s.Bans), snapshotVersion)
+	}
+
+	time.Sleep(150 * time.Millisecond) // short 过期，brief 解封
+
+	dst := New(Options{MinAnonymity: AnonymityElite})
+	n, err := dst.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// low 匿名等级不达标，short 已过期
+	if n != 1 || dst.Size() != 1 {
+		t.Fatalf("restored %d, size %d; want 1", n, dst.Size())
+	}
+	got := dst.List()[0]
+	if got.Addr != "http://a:1" || got.Source != "s1" || got.Tag != "t" || got.Labels["country"] != "us" ||
+		got.ExitIP != "203.0.113.1" || got.Anonymity != AnonymityElite || got.Success != 2 || got.LatencyEWMA <= 0 {
+		t.Fatalf("restored entry = %+v", got)
+	}
+	bans := dst.Bans()
+	if len(bans) != 1 || bans[0].Addr != "http://banned:1" || !bans[0].Until.IsZero() {
+		t.Fatalf("restored bans = %+v, want only the permanent one", bans)
+	}
+	if r := dst.AddFrom("http://banned:1", time.Hour, Origin{Anonymity: AnonymityElite}); r != Banned {
+		t.Fatalf("AddFrom banned after restore = %v, want Banned", r)
+	}
+}
+
+func TestRestoreDrops(t *testing.T) {
+	now := time.Now()
+	s := Snapshot{
+		Version: snapshotVersion,
+		Proxies: []ProxyRecord{
+			{Addr: "http://ok:1", ExpireAt: now.Add(time.Hour), Anonymity: AnonymityAnonymous},
+			{Addr: "http://expired:1", ExpireAt: now.Add(-time.Second), Anonymity: AnonymityElite},
+			{Addr: "http://banned:1", ExpireAt: now.Add(time.Hour), Anonymity: AnonymityElite},
+			{Addr: "http://unbanned:1", ExpireAt: now.Add(time.Hour), Anonymity: AnonymityElite},
+			{Addr: "http://transparent:1", ExpireAt: now.Add(time.Hour), Anonymity: AnonymityTransparent},
+			{Addr: "http://present:1", ExpireAt: now.Add(time.Hour), Anonymity: AnonymityElite, Success: 9},
+			{Addr: "", ExpireAt: now.Add(time.Hour)},
+		},
+		Bans: []Ban{
+			{Addr: "http://banned:1", Until: now.Add(time.Hour)},
+			{Addr: "http://unbanned:1", Until: now.Add(-time.Second)},
+		},
+	}
+	p := New(Options{MinAnonymity: AnonymityAnonymous})
+	p.AddFrom("http://present:1", time.Hour, Origin{Anonymity: AnonymityElite})
+	n, err := p.Restore(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addrs []string
+	for _, info := range p.List() {
+		addrs = append(addrs, info.Addr)
+		if info.Addr == "http://present:1" && info.Success != 0 {
+			t.Errorf("entry already in the pool was overwritten: %+v", info)
+		}
+	}
+	if n != 2 || strings.Join(addrs, " ") != "http://present:1 http://ok:1 http://unbanned:1" {
+		t.Fatalf("restored %d: %v", n, addrs)
+	}
+
+	s.Version = snapshotVersion + 1
+	if _, err := New(Options{}).Restore(s); err == nil {
+		t.Fatal("Restore accepted an unknown version")
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	p := New(Options{})
+	if n, err := p.LoadFile(filepath.Join(dir, "missing.json")); n != 0 || err != nil {
+		t.Fatalf("LoadFile(missing) = %d, %v; want 0, nil", n, err)
+	}
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.LoadFile(bad); err == nil || !strings.Contains(err.Error(), "decode snapshot") {
+		t.Fatalf("LoadFile(corrupt) error = %v", err)
+	}
+	// 目录不存在时创建临时文件即失败
+	if err := p.SaveFile(filepath.Join(dir, "nope", "pool.json")); err == nil {
+		t.Fatal("SaveFile into a missing directory succeeded")
+	}
+}
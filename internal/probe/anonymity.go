@@ -0,0 +1,144 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+)
+
+// 代理常见的痕迹请求头（小写）；目标收到任一个即说明请求经过了代理
+var proxyHeaders = []string{
+	"via", "x-forwarded-for", "forwarded", "x-real-ip", "x-forwarded-host", "x-forwarded-proto",
+	"x-proxy-id", "x-bluecoat-via", "client-ip", "x-client-ip", "proxy-client-ip", "proxy-connection",
+}
+
+// Classifier 经上游请求请求头回显地址（本 Prober 的 URL，如 httpbin 的 /get），
+// 按目标看到的请求判定上游的匿名等级
+type Classifier struct {
+	prober *Prober
+
+	mu     sync.Mutex
+	realIP string // 不经代理时的出口 IP；首次判定时获取，失败则下次重试
+}
+
+// NewClassifier 创建匿名等级判定
+func NewClassifier(p *Prober) *Classifier {
+	return &Classifier{prober: p}
+}
+
+// Classify 判定上游 addr 的匿名等级：回显中出现本机真实 IP 为 transparent，
+// 带有 Via / X-Forwarded-For 等代理痕迹为 anonymous，否则为 elite
+func (c *Classifier) Classify(ctx context.Context, addr, source string) (pool.Anonymity, error) {
+	realIP, err := c.RealIP(ctx)
+	if err != nil {
+		return pool.AnonymityUnknown, fmt.Errorf("real ip: %w", err)
+	}
+	res, err := c.prober.check(ctx, addr, source, ModeGet)
+	if err != nil {
+		return pool.AnonymityUnknown, err
+	}
+	return classify(res.Body, realIP), nil
+}
+
+// RealIP 不经代理直接请求回显地址，返回本机的出口 IP；成功后缓存
+func (c *Classifier) RealIP(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.realIP != "" {
+		return c.realIP, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.prober.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.prober.target.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	tr := &http.Transport{DisableKeepAlives: true}
+	defer tr.CloseIdleConnections()
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("%s: %s", c.prober.target, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return "", err
+	}
+	ip, err := ParseIP(body)
+	if err != nil {
+		return "", err
+	}
+	c.realIP = ip
+	return ip, nil
+}
+
+// classify 按回显内容判定匿名等级
+func classify(body []byte, realIP string) pool.Anonymity {
+	if containsIP(body, realIP) {
+		return pool.AnonymityTransparent
+	}
+	headers := echoHeaders(body)
+	for _, h := range proxyHeaders {
+		if _, ok := headers[h]; ok {
+			return pool.AnonymityAnonymous
+		}
+	}
+	return pool.AnonymityElite
+}
+
+// containsIP 回显中是否出现 ip（按完整的 IP 词匹配，"1.2.3.4" 不匹配 "1.2.3.45"）
+func containsIP(body []byte, ip string) bool {
+	want := net.ParseIP(ip)
+	if want == nil {
+		return false
+	}
+	words := bytes.FieldsFunc(body, func(r rune) bool {
+		return !(r == '.' || r == ':' || '0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F')
+	})
+	for _, w := range words {
+		if got := net.ParseIP(string(w)); got != nil && got.Equal(want) {
+			return true
+		}
+	}
+	return false
+}
+
+// echoHeaders 取出回显的请求头（名称转小写）：JSON 取 "headers" 对象（没有则取顶层对象），
+// 否则按 "Name: value" 逐行解析
+func echoHeaders(body []byte) map[string]string {
+	out := make(map[string]string)
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '{' {
+		var obj map[string]any
+		if err := json.Unmarshal(body, &obj); err == nil {
+			hs, ok := obj["headers"].(map[string]any)
+			if !ok {
+				hs = obj
+			}
+			for k, v := range hs {
+				s, _ := v.(string)
+				out[strings.ToLower(k)] = s
+			}
+			return out
+		}
+	}
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	for sc.Scan() {
+		if k, v, ok := strings.Cut(sc.Text(), ":"); ok && !strings.ContainsAny(k, " \t") {
+			out[strings.ToLower(k)] = strings.TrimSpace(v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+package probe
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+)
+
+func TestContainsIP(t *testing.T) {
+	tests := []struct {
+		body, ip string
+		want     bool
+	}{
+		{`{"origin":"198.51.100.1"}`, "198.51.100.1", true},
+		{`{"origin":"198.51.100.10"}`, "198.51.100.1", false},
+		{`{"origin":"198.51.100.1, 203.0.113.7"}`, "203.0.113.7", true},
+		{"X-Forwarded-For: 2001:db8::1", "2001:db8:0::1", true},
+		{"X-Forwarded-For: 2001:db8::10", "2001:db8::1", false},
+		{`{"origin":"203.0.113.7"}`, "", false},
+	}
+	for _, tt := range tests {
+		if got := containsIP([]byte(tt.body), tt.ip); got != tt.want {
+			t.Errorf("containsIP(%q, %q) = %v, want %v", tt.body, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	const realIP = "198.51.100.1"
+	tests := []struct {
+		name string
+		body string
+		want pool.Anonymity
+	}{
+		{"transparent origin", `{"origin":"198.51.100.1","headers":{}}`, pool.AnonymityTransparent},
+		{"transparent forwarded", `{"origin":"203.0.113.7","headers":{"X-Forwarded-For":"198.51.100.1"}}`, pool.AnonymityTransparent},
+		{"anonymous via", `{"origin":"203.0.113.7","headers":{"Via":"1.1 squid"}}`, pool.AnonymityAnonymous},
+		{"anonymous forwarded", `{"origin":"203.0.113.7","headers":{"X-Forwarded-For":"10.1.1.1"}}`, pool.AnonymityAnonymous},
+		{"anonymous top-level headers", `{"Forwarded":"for=10.1.1.1","origin":"203.0.113.7"}`, pool.AnonymityAnonymous},
+		{"anonymous plain text", "Host: example.com\nProxy-Connection: keep-alive\n", pool.AnonymityAnonymous},
+		{"elite", `{"origin":"203.0.113.7","headers":{"Host":"example.com","User-Agent":"Go-http-client/1.1"}}`, pool.AnonymityElite},
+		{"elite plain text", "Host: example.com\nUser-Agent: curl\n", pool.AnonymityElite},
+	}
+	for _, tt := range tests {
+		if got := classify([]byte(tt.body), realIP); got != tt.want {
+			t.Errorf("%s: classify = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClassifier(t *testing.T) {
+	echo := echoServer(t) // 直连时回显 origin 127.0.0.1，即本机“真实 IP”
+	exit := func(h http.Header) { h.Set("X-Test-Origin", "203.0.113.7") }
+	tests := []struct {
+		name   string
+		modify func(http.Header)
+		want   pool.Anonymity
+	}{
+		{"transparent", func(h http.Header) { exit(h); h.Set("X-Forwarded-For", "127.0.0.1") }, pool.AnonymityTransparent},
+		{"anonymous", func(h http.Header) { exit(h); h.Set("Via", "1.1 test-proxy") }, pool.AnonymityAnonymous},
+		{"elite", exit, pool.AnonymityElite},
+	}
+	p, err := New(Options{URL: echo.URL, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClassifier(p)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Classify(context.Background(), testProxy(t, tt.modify), "")
+			if err != nil || got != tt.want {
+				t.Fatalf("Classify = %s, %v; want %s", got, err, tt.want)
+			}
+		})
+	}
+	if ip, err := c.RealIP(context.Background()); err != nil || ip != "127.0.0.1" {
+		t.Fatalf("RealIP = %q, %v", ip, err)
+	}
+	if got, err := c.Classify(context.Background(), deadUpstream(t), ""); err == nil || got != pool.AnonymityUnknown {
+		t.Fatalf("Classify through a dead upstream = %s, %v", got, err)
+	}
+}
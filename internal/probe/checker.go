@@ -0,0 +1,80 @@
+package probe
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+)
+
+// Checker 周期性地检测池中每个上游：成功计入延迟与成功数，失败按池子的阈值隔离或淘汰，
+// 赶在客户端请求之前发现坏上游；隔离中的上游检测通过后提前恢复
+type Checker struct {
+	prober   *Prober
+	pool     *pool.Pool
+	interval time.Duration
+}
+
+// NewChecker 创建后台检测；interval 为两轮检测之间的间隔
+func NewChecker(pr *Prober, pl *pool.Pool, interval time.Duration) *Checker {
+	return &Checker{prober: pr, pool: pl, interval: interval}
+}
+
+// Run 循环检测直到 ctx 取消；上一轮未完成时不会开始下一轮
+func (c *Checker) Run(ctx context.Context) {
+	tk := time.NewTicker(c.interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			c.round(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// round 并发检测当前池中所有上游，并发数受 Prober 的全局名额限制
+func (c *Checker) round(ctx context.Context) {
+	list := c.pool.List()
+	if len(list) == 0 {
+		return
+	}
+	start := time.Now()
+	var (
+		wg                  sync.WaitGroup
+		ok, failed, dropped atomic.Int32
+		recovered           atomic.Int32
+	)
+	for _, info := range list {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.prober.Check(ctx, info.Addr, info.Source)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				failed.Add(1)
+				evicted := c.pool.ReportFailure(info.Addr)
+				if evicted {
+					dropped.Add(1)
+				}
+				log.Printf("[HEALTH] fail %q: %v evicted=%v", info.Addr, err, evicted)
+				return
+			}
+			ok.Add(1)
+			c.pool.ReportSuccess(info.Addr, res.Latency)
+			if c.pool.Unquarantine(info.Addr) {
+				recovered.Add(1)
+				log.Printf("[HEALTH] recovered %q latency=%s", info.Addr, res.Latency)
+			}
+		}()
+	}
+	wg.Wait()
+	log.Printf("[HEALTH] round checked=%d ok=%d failed=%d evicted=%d recovered=%d size=%d took=%s",
+		len(list), ok.Load(), failed.Load(), dropped.Load(), recovered.Load(), c.pool.Size(), time.Since(start).Round(time.Millisecond))
+}
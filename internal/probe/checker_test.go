@@ -0,0 +1,90 @@
+package probe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+)
+
+func TestCheckerRound(t *testing.T) {
+	echo := echoServer(t)
+	good, dead := testProxy(t, nil), deadUpstream(t)
+	pr, err := New(Options{URL: echo.URL, Timeout: 2 * time.Second, DialTimeout: time.Second, Concurrency: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		quarantine  time.Duration
+		quarantined bool // good 检测前已被隔离
+		keepDead    bool // dead 检测后仍在池中（被隔离）
+	}{
+		{"evict", 0, false, false},
+		{"quarantine and recover", time.Minute, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := pool.New(pool.Options{FailThreshold: 1, Quarantine: tt.quarantine})
+			p.AddFrom(good, time.Hour, pool.Origin{Source: "a"})
+			p.AddFrom(dead, time.Hour, pool.Origin{Source: "a"})
+			if tt.quarantined {
+				p.ReportFailure(good) // 先把 good 隔离
+			}
+
+			NewChecker(pr, p, time.Hour).round(context.Background())
+
+			stats := make(map[string]pool.Info)
+			for _, info := range p.List() {
+				stats[info.Addr] = info
+			}
+			g, ok := stats[good]
+			if !ok || g.Success != 1 || !g.QuarantineUntil.IsZero() || g.LatencyEWMA <= 0 {
+				t.Fatalf("good = %+v (in pool %v), want one success and not quarantined", g, ok)
+			}
+			d, ok := stats[dead]
+			if ok != tt.keepDead {
+				t.Fatalf("dead in pool = %v, want %v", ok, tt.keepDead)
+			}
+			if ok && (d.Failure != 1 || d.QuarantineUntil.IsZero()) {
+				t.Fatalf("dead = %+v, want one failure and quarantined", d)
+			}
+			if p.Usable() != 1 {
+				t.Fatalf("usable = %d, want 1", p.Usable())
+			}
+		})
+	}
+}
+
+func TestCheckerRun(t *testing.T) {
+	echo := echoServer(t)
+	pr, err := New(Options{URL: echo.URL, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pool.New(pool.Options{FailThreshold: 1})
+	good := testProxy(t, nil)
+	p.AddFrom(good, time.Hour, pool.Origin{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewChecker(pr, p, 10*time.Millisecond).Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for p.List()[0].Success < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("checker did not run two rounds")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
@@ -0,0 +1,50 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ExitIP 经由上游 addr 请求 IP 回显地址（本 Prober 的 URL），返回上游的出口 IP。
+// 无论 --check-mode 如何都发 GET。
+func (p *Prober) ExitIP(ctx context.Context, addr, source string) (string, error) {
+	res, err := p.check(ctx, addr, source, ModeGet)
+	if err != nil {
+		return "", err
+	}
+	return ParseIP(res.Body)
+}
+
+// 回显 JSON 中可能存放 IP 的字段，依次尝试
+var ipFields = []string{"ip", "origin", "query", "ip_addr", "address", "clientIp", "client_ip"}
+
+// ParseIP 从回显响应中取出 IP：JSON 对象取 ip/origin/query 等字段（"a, b" 取第一个），
+// 否则按纯文本取第一个词
+func ParseIP(body []byte) (string, error) {
+	body = bytes.TrimSpace(body)
+	text := string(body)
+	if len(body) > 0 && body[0] == '{' {
+		var obj map[string]any
+		if err := json.Unmarshal(body, &obj); err == nil {
+			text = ""
+			for _, k := range ipFields {
+				if v, ok := obj[k].(string); ok && v != "" {
+					text = v
+					break
+				}
+			}
+		}
+	}
+	first, _, _ := strings.Cut(text, ",")
+	fields := strings.Fields(first)
+	if len(fields) > 0 {
+		if ip := net.ParseIP(fields[0]); ip != nil {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no ip in echo response %.64q", body)
+}
@@ -0,0 +1,52 @@
+package probe
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseIP(t *testing.T) {
+	tests := []struct {
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"203.0.113.7\n", "203.0.113.7", false},
+		{"  203.0.113.7 extra words", "203.0.113.7", false},
+		{"2001:db8::1", "2001:db8::1", false},
+		{`{"ip":"203.0.113.7"}`, "203.0.113.7", false},
+		{`{"origin":"203.0.113.7, 10.0.0.1"}`, "203.0.113.7", false},
+		{`{"query":"203.0.113.7","status":"success"}`, "203.0.113.7", false},
+		{`{"clientIp":"::ffff:203.0.113.7"}`, "203.0.113.7", false},
+		{`{"ip":"","origin":"203.0.113.7"}`, "203.0.113.7", false},
+		{`{"status":"fail"}`, "", true},
+		{`{"ip":"not an ip"}`, "", true},
+		{"<html>blocked</html>", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseIP([]byte(tt.body))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseIP(%q) = %q, %v; want %q, err %v", tt.body, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestExitIP(t *testing.T) {
+	echo := echoServer(t)
+	up := testProxy(t, func(h http.Header) { h.Set("X-Test-Origin", "203.0.113.7") })
+	p, err := New(Options{URL: echo.URL, Mode: ModeConnect, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// connect 模式下也发 GET 取回显
+	ip, err := p.ExitIP(context.Background(), up, "")
+	if err != nil || ip != "203.0.113.7" {
+		t.Fatalf("ExitIP = %q, %v; want 203.0.113.7", ip, err)
+	}
+	if _, err := p.ExitIP(context.Background(), deadUpstream(t), ""); err == nil {
+		t.Fatal("ExitIP through a dead upstream succeeded")
+	}
+}
@@ -0,0 +1,180 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/upstream"
+)
+
+// 检测方式（--check-mode）
+const (
+	ModeGet     = "get"     // 经上游请求检测地址，要求 2xx
+	ModeConnect = "connect" // 只要求经上游建立到检测地址的隧道
+)
+
+// maxBody 检测响应体最多读取的字节数
+const maxBody = 64 << 10
+
+// Options 检测参数
+type Options struct {
+	URL         string        // 检测地址，http:// 或 https://
+	Mode        string        // ModeGet / ModeConnect；空为 ModeGet
+	Timeout     time.Duration // 单次检测的总超时（含建连、握手与读响应）
+	Concurrency int           // 同时进行的检测数上限；<=0 视为 1
+
+	DialTimeout time.Duration
+	UpstreamTLS *tls.Config            // https:// 上游的 TLS 配置
+	SourceTLS   map[string]*tls.Config // 按来源名称覆盖 UpstreamTLS
+}
+
+// Result 一次成功检测的结果
+type Result struct {
+	Latency time.Duration // 从开始拨号到拿到响应头（connect 模式为隧道建立）的耗时
+	Status  int           // HTTP 状态码；connect 模式为 0
+	Body    []byte        // 响应体（最多 64KB）
+}
+
+// Prober 经由候选上游访问检测地址，判断其是否可用；并发受 Concurrency 限制
+type Prober struct {
+	opts   Options
+	target *url.URL
+	addr   string // 检测地址的 host:port
+	sem    chan struct{}
+}
+
+// New 校验检测参数并创建 Prober
+func New(opts Options) (*Prober, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid check url %q: want http:// or https://", opts.URL)
+	}
+	opts.Mode = strings.ToLower(strings.TrimSpace(opts.Mode))
+	switch opts.Mode {
+	case "":
+		opts.Mode = ModeGet
+	case ModeGet, ModeConnect:
+	default:
+		return nil, fmt.Errorf("unknown check mode %q (supported: %s, %s)", opts.Mode, ModeGet, ModeConnect)
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	return &Prober{
+		opts:   opts,
+		target: u,
+		addr:   net.JoinHostPort(u.Hostname(), port),
+		sem:    make(chan struct{}, opts.Concurrency),
+	}, nil
+}
+
+// WithURL 返回检测地址换成 rawURL 的 Prober，与原 Prober 共用并发名额
+func (p *Prober) WithURL(rawURL string) (*Prober, error) {
+	opts := p.opts
+	opts.URL = rawURL
+	np, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+	np.sem = p.sem
+	return np, nil
+}
+
+// URL 返回检测地址
+func (p *Prober) URL() string { return p.opts.URL }
+
+// Check 经由上游 addr 检测；source 用于选择来源级 TLS 配置。
+// 等待并发名额的时间不计入超时，但受 ctx 约束。
+func (p *Prober) Check(ctx context.Context, addr, source string) (Result, error) {
+	return p.check(ctx, addr, source, p.opts.Mode)
+}
+
+func (p *Prober) check(ctx context.Context, addr, source, mode string) (Result, error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
+	defer func() { <-p.sem }()
+
+	u, err := upstream.Parse(addr)
+	if err != nil {
+		return Result{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+	defer cancel()
+
+	dopts := p.dialOptions(source)
+	start := time.Now()
+	if mode == ModeConnect {
+		conn, err := upstream.Dial(ctx, u, p.addr, dopts)
+		if err != nil {
+			return Result{}, err
+		}
+		_ = conn.Close()
+		return Result{Latency: time.Since(start)}, nil
+	}
+
+	// http 上游走代理转发（https 目标由 Transport 发 CONNECT），https 上游对 http 目标同样转发、
+	// 只是连接套了 TLS，与实际流量的转发方式一致；其他上游先建隧道
+	tr := &http.Transport{
+		DisableKeepAlives:   true,
+		TLSHandshakeTimeout: p.opts.Timeout,
+	}
+	switch {
+	case u.Scheme == "http":
+		tr.Proxy = http.ProxyURL(u)
+		tr.DialContext = (&net.Dialer{Timeout: p.opts.DialTimeout}).DialContext
+	case u.Scheme == "https" && p.target.Scheme == "http":
+		tr.Proxy = http.ProxyURL(u)
+		tr.DialTLSContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return upstream.DialTLS(ctx, u, dopts)
+		}
+	default:
+		tr.DialContext = func(ctx context.Context, _, target string) (net.Conn, error) {
+			return upstream.Dial(ctx, u, target, dopts)
+		}
+	}
+	defer tr.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.target.String(), nil)
+	if err != nil {
+		return Result{}, err
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	latency := time.Since(start)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return Result{}, fmt.Errorf("check %s: %s", p.target, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return Result{}, fmt.Errorf("check %s: read body: %w", p.target, err)
+	}
+	return Result{Latency: latency, Status: resp.StatusCode, Body: body}, nil
+}
+
+func (p *Prober) dialOptions(source string) upstream.Options {
+	opts := upstream.Options{Timeout: p.opts.DialTimeout, TLS: p.opts.UpstreamTLS}
+	if cfg, ok := p.opts.SourceTLS[source]; ok {
+		opts.TLS = cfg
+	}
+	return opts
+}
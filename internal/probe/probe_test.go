@@ -0,0 +1,192 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServer 回显看到的请求：origin 为 X-Test-Origin（测试代理填入的“出口 IP”），
+// 没有则为 127.0.0.1；headers 为收到的请求头
+func echoServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("X-Test-Origin")
+		if origin == "" {
+			origin = "127.0.0.1"
+		}
+		headers := make(map[string]string)
+		for k := range r.Header {
+			headers[k] = r.Header.Get(k)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"origin": origin, "headers": headers})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testProxy 最小的 http 上游：转发绝对 URI 请求（转发前由 modify 改写请求头），支持 CONNECT；
+// 返回 "http://host:port"
+func testProxy(t *testing.T, modify func(http.Header)) string {
+	t.Helper()
+	tr := &http.Transport{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			up, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				up.Close()
+				return
+			}
+			_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+			go func() {
+				_, _ = io.Copy(up, conn)
+				up.Close()
+			}()
+			_, _ = io.Copy(conn, up)
+			conn.Close()
+			return
+		}
+		out := r.Clone(r.Context())
+		out.RequestURI = ""
+		if modify != nil {
+			modify(out.Header)
+		}
+		resp, err := tr.RoundTrip(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(func() {
+		srv.Close()
+		tr.CloseIdleConnections()
+	})
+	return srv.URL
+}
+
+// deadUpstream 一个没有监听的地址
+func deadUpstream(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return "http://" + addr
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		opts    Options
+		wantErr string
+	}{
+		{Options{URL: "http://example.com/"}, ""},
+		{Options{URL: "https://example.com/", Mode: " CONNECT "}, ""},
+		{Options{URL: "ftp://example.com/"}, "invalid check url"},
+		{Options{URL: "http://"}, "invalid check url"},
+		{Options{URL: "http://example.com/", Mode: "ping"}, "unknown check mode"},
+	}
+	for _, tt := range tests {
+		p, err := New(tt.opts)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("New(%+v) error = %v, want %q", tt.opts, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("New(%+v): %v", tt.opts, err)
+			continue
+		}
+		if p.opts.Concurrency != 1 || p.opts.Timeout <= 0 {
+			t.Errorf("New(%+v) defaults = %d %s", tt.opts, p.opts.Concurrency, p.opts.Timeout)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	echo := echoServer(t)
+	status := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer status.Close()
+	good, dead := testProxy(t, nil), deadUpstream(t)
+
+	tests := []struct {
+		name     string
+		url      string
+		mode     string
+		upstream string
+		wantErr  bool
+		status   int
+	}{
+		{"get ok", echo.URL, ModeGet, good, false, http.StatusOK},
+		{"get non-2xx", status.URL, ModeGet, good, true, 0},
+		{"get dead upstream", echo.URL, ModeGet, dead, true, 0},
+		{"connect ok", status.URL, ModeConnect, good, false, 0},
+		{"connect dead upstream", echo.URL, ModeConnect, dead, true, 0},
+		{"bad upstream scheme", echo.URL, ModeGet, "ftp://127.0.0.1:21", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(Options{URL: tt.url, Mode: tt.mode, Timeout: 2 * time.Second, DialTimeout: time.Second})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := p.Check(context.Background(), tt.upstream, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if res.Status != tt.status || res.Latency <= 0 {
+				t.Fatalf("Check = status %d latency %s, want status %d", res.Status, res.Latency, tt.status)
+			}
+			if tt.mode == ModeGet && !strings.Contains(string(res.Body), `"origin"`) {
+				t.Fatalf("Check body = %q", res.Body)
+			}
+		})
+	}
+}
+
+// 并发名额已满时等待名额受 ctx 约束
+func TestCheckWaitsForSlot(t *testing.T) {
+	p, err := New(Options{URL: "http://example.com/", Concurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.sem <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.Check(ctx, "http://127.0.0.1:1", ""); err != context.DeadlineExceeded {
+		t.Fatalf("Check error = %v, want deadline exceeded", err)
+	}
+
+	q, err := p.WithURL("http://example.org/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.URL() != "http://example.org/" || q.sem != p.sem {
+		t.Fatalf("WithURL = %q, shares slots %v", q.URL(), q.sem == p.sem)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+)
+
+// proxyError 表示无法经由上游完成请求，携带返回给客户端的状态码
+type proxyError struct {
+	status     int
+	reason     string // 状态行原因短语；空则使用标准文本
+	msg        string
+	retryAfter time.Duration // >0 时附带 Retry-After 头
+}
+
+func (e *proxyError) Error() string { return e.msg }
+
+// errPoolEmpty 严格模式下等待超时仍无可用上游
+func errPoolEmpty(wait time.Duration) *proxyError {
+	return &proxyError{
+		status:     http.StatusServiceUnavailable,
+		reason:     "Pool Empty",
+		msg:        "proxy pool is empty",
+		retryAfter: max(wait, time.Second),
+	}
+}
+
+// retryAfterSeconds 向上取整到秒
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+// noUpstream 处理第一次挑选就没有结果的情况。池中有可用代理但都不满足 sel 时立即返回 errNoMatch，
+// 不必等待；只有池子确实没有可用代理时，严格模式才等待新代理入池，超时返回 errPoolEmpty。
+// 返回空地址且无错误时由调用方按策略直连或返回 502。target 仅用于日志。
+func (s *Server) noUpstream(tag, target, session string, sel selection, f pool.Filter) (string, error) {
+	opts := s.options()
+	if len(sel) > 0 && opts.Pool.Usable() > 0 {
+		log.Printf("[%s] no upstream matches %s -> 503 %s", tag, sel, target)
+		return "", errNoMatch(sel)
+	}
+	if opts.Strict {
+		if addr, ok := s.waitUpstream(session, f); ok {
+			return addr, nil
+		}
+		log.Printf("[%s] pool empty -> 503 %s", tag, target)
+		return "", errPoolEmpty(opts.EmptyWait)
+	}
+	if len(sel) > 0 {
+		log.Printf("[%s] no upstream matches %s -> 503 %s", tag, sel, target)
+		return "", errNoMatch(sel)
+	}
+	return "", nil
+}
+
+// waitUpstream 严格模式下池子为空时：通知立即拉取，并最多等待 EmptyWait 直到有满足 f 的代理入池
+func (s *Server) waitUpstream(session string, f pool.Filter) (string, bool) {
+	opts := s.options()
+	if opts.OnEmpty != nil {
+		opts.OnEmpty()
+	}
+	log.Printf("[POOL] empty -> waiting up to %s for an upstream", opts.EmptyWait)
+	timer := time.NewTimer(opts.EmptyWait)
+	defer timer.Stop()
+	for {
+		added := opts.Pool.Added()
+		if addr, ok := s.sessions.pick(opts.Pool, session, f); ok {
+			return addr, true
+		}
+		select {
+		case <-added:
+		case <-timer.C:
+			return "", false
+		}
+	}
+}
+
+// excluding 返回排除已尝试上游的过滤器
+func excluding(tried map[string]struct{}) pool.Filter {
+	return func(pr *pool.Proxy) bool {
+		_, ok := tried[pr.Addr]
+		return !ok
+	}
+}
+
+// writeConnectError 作为 goproxy 的 ConnectionErrHandler：CONNECT 建立失败时按错误回写状态码。
+// 隧道建立之后的转发错误也会进到这里，此时连接上已是隧道数据，不能再写 HTTP 响应。
+func writeConnectError(w io.Writer, ctx *goproxy.ProxyCtx, err error) {
+	var pe *proxyError
+	if !errors.As(err, &pe) {
+		ctx.Warnf("tunnel error: %v", err)
+		return
+	}
+	reason := pe.reason
+	if reason == "" {
+		reason = http.StatusText(pe.status)
+	}
+	extra := ""
+	if pe.retryAfter > 0 {
+		extra = "Retry-After: " + retryAfterSeconds(pe.retryAfter) + "\r\n"
+	}
+	_, _ = fmt.Fprintf(w, "HTTP/1.1 %d %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n%sConnection: close\r\n\r\n%s",
+		pe.status, reason, len(pe.msg), extra, pe.msg)
+}
+
+// errorResponse 作为 goproxy 的响应处理器：普通 HTTP 转发失败时返回对应状态码（默认 502），
+// 替代 goproxy 默认的 500。
+func errorResponse(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
+	if resp != nil || ctx.Error == nil {
+		return resp
+	}
+	status := http.StatusBadGateway
+	var pe *proxyError
+	if errors.As(ctx.Error, &pe) {
+		status = pe.status
+	}
+	resp = goproxy.NewResponse(ctx.Req, goproxy.ContentTypeText, status, ctx.Error.Error())
+	if pe != nil && pe.retryAfter > 0 {
+		resp.Header.Set("Retry-After", retryAfterSeconds(pe.retryAfter))
+	}
+	return resp
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+)
+
+// 严格模式下带标签筛选：池中有代理但都不匹配时立即返回 No Matching Upstream，
+// 只有池子确实为空时才等待 EmptyWait 后返回 Pool Empty
+func TestStrictWithSelection(t *testing.T) {
+	const wait = 300 * time.Millisecond
+	tests := []struct {
+		name   string
+		labels map[string]string // 池中唯一代理的标签；nil 表示池子为空
+		reason string
+		waits  bool
+	}{
+		{"pool has other labels", map[string]string{"country": "de"}, "No Matching Upstream", false},
+		{"pool empty", nil, "Pool Empty", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := pool.New(pool.Options{})
+			if tt.labels != nil {
+				p.AddFrom("http://127.0.0.1:1", time.Minute, pool.Origin{Source: "test", Labels: tt.labels})
+			}
+			s := New(Options{Pool: p, Strict: true, EmptyWait: wait, RetryTimeout: 5 * time.Second, DialTimeout: time.Second})
+			sel := selection{"country": "us"}
+
+			start := time.Now()
+			_, err := s.dialTarget("CONNECT", "", sel, "tcp", "example.com:443")
+			elapsed := time.Since(start)
+			var pe *proxyError
+			if !errors.As(err, &pe) || pe.status != http.StatusServiceUnavailable || pe.reason != tt.reason {
+				t.Fatalf("dialTarget error = %v, want 503 %s", err, tt.reason)
+			}
+			if waited := elapsed >= wait; waited != tt.waits {
+				t.Fatalf("dialTarget took %s, want waiting EmptyWait = %v", elapsed, tt.waits)
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+			if _, err := s.roundTrip(req, "", sel); !errors.As(err, &pe) || pe.reason != tt.reason {
+				t.Fatalf("roundTrip error = %v, want 503 %s", err, tt.reason)
+			}
+		})
+	}
+}
@@ -1,132 +1,406 @@
 package server
 
 import (
-	"bufio"
+	"context"
 	"crypto/tls"
-	"encoding/base64"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/elazarl/goproxy"
+	"github.com/lianshufeng/proxy-pool/internal/acl"
+	"github.com/lianshufeng/proxy-pool/internal/auth"
+	"github.com/lianshufeng/proxy-pool/internal/metrics"
 	"github.com/lianshufeng/proxy-pool/internal/pool"
+	"github.com/lianshufeng/proxy-pool/internal/upstream"
 )
 
 type Server struct {
-	httpSrv *http.Server
-	proxy   *goproxy.ProxyHttpServer
-	opts    Options
+	httpSrv  *http.Server
+	proxy    *goproxy.ProxyHttpServer
+	tr       *http.Transport // http 上游：通过 Proxy 转发
+	tunnelTr *http.Transport // https/socks 上游：先建隧道，不复用连接
+	tlsTr    *http.Transport // https 上游转发普通 http:// 请求：TLS 连到上游后按绝对 URI 转发
+	// Update 整体替换，请求处理中通过 options() 读取
+	opts     atomic.Pointer[Options]
+	sessions *sessions
+	rejected atomic.Uint64 // 被来源 ACL 拒绝的连接数
+
+	mu      sync.Mutex
+	socksLn net.Listener // SOCKS5 入站监听；未开启时为 nil
 }
 
 type Options struct {
 	Listen              string
+	SocksListen         string // SOCKS5 入站监听地址；留空不开启
 	Pool                *pool.Pool
 	DialTimeout         time.Duration
 	IdleConns           int
 	IdleTimeout         time.Duration
 	TLSHandshakeTimeout time.Duration
-}
 
-// 兼容解析：支持 http:// 以及无 scheme 的 "user:pass@host:port" / "host:port"
-// 现在仅考虑 HTTP 代理：传入 https:// 或 socks5:// 会被判定为不支持并从池中删除。
-func parseUpstream(addr string) (u *url.URL, hasScheme bool, err error) {
-	if strings.Contains(addr, "://") {
-		u, err = url.Parse(addr)
-		return u, true, err
-	}
-	u, err = url.Parse("//" + addr) // 把它当 authority 解析
-	return u, false, err
+	// 粘性会话：同一会话 key 在 TTL 内固定使用同一上游
+	SessionTTL  time.Duration // <=0 关闭会话保持
+	SessionByIP bool          // 无显式会话 key 时按客户端 IP 绑定
+
+	// 故障转移：失败后换其他上游重试，耗尽后默认返回 502
+	Retries      int           // 首次失败后最多再尝试的上游个数
+	RetryTimeout time.Duration // 含重试在内的总截止时间（到拿到响应头/隧道建立为止）
+	AllowDirect  bool          // 重试耗尽后是否允许直连目标（会暴露本机出口 IP）
+
+	// 严格模式：池子为空时不直连，而是等待新代理入池，超时返回 503 Pool Empty
+	Strict    bool
+	EmptyWait time.Duration
+	OnEmpty   func() // 池子为空时回调，用于触发一次立即拉取；需非阻塞
+
+	// 入站认证：nil 表示不启用
+	Users     *auth.Users
+	AuthRealm string
+
+	// 入站来源 ACL：在 Accept 阶段拒绝，不做任何 HTTP 解析；nil 表示不限制
+	SourceACL *acl.SourceACL
+
+	// 用户名中可作为标签筛选的参数名（除 DefaultSelectKeys 外），通常取自来源映射的 label.<name>
+	SelectKeys []string
+
+	// 目标规则：按目标 host/port 拒绝、直连或强制走池子；nil 表示不限制
+	Rules *acl.Rules
+
+	// https:// 上游的 TLS 配置（CA、SNI、跳过校验）；nil 使用系统默认
+	UpstreamTLS *tls.Config
+	SourceTLS   map[string]*tls.Config // 按来源名称覆盖 UpstreamTLS
 }
 
-// 动态删除上游：通过多种可能的方法名做类型断言，尽量兼容你的 pool 实现。
-// 如果没有对应方法，会打印日志但不 panic。
+// removeFromPool 永久性错误（解析失败、scheme 不支持）时直接删除上游
 func removeFromPool(p *pool.Pool, addr string) {
-	type remover interface{ Remove(string) }
-	type deleter interface{ Delete(string) }
-	type del interface{ Del(string) }
-	type ban interface{ Ban(string) }
-	type blacklist interface{ Blacklist(string) }
-	type invalidate interface{ Invalidate(string) }
-	type markbad interface{ MarkBad(string) }
-	type fail interface{ Fail(string) }
-	type drop interface{ Drop(string) }
-
 	if addr == "" {
 		return
 	}
-	if v, ok := any(p).(remover); ok {
-		v.Remove(addr)
-		return
+	if !p.Remove(addr) {
+		log.Printf("[POOL] remove %q: not in pool", addr)
 	}
-	if v, ok := any(p).(deleter); ok {
-		v.Delete(addr)
-		return
+}
+
+// reportFailure 上报一次瞬时失败（超时、握手失败等），由池子按阈值决定是否淘汰。
+// 解析失败、scheme 不支持这类永久性错误仍直接走 removeFromPool。
+func reportFailure(p *pool.Pool, addr string) {
+	if p.ReportFailure(addr) {
+		log.Printf("[POOL] upstream %q reached failure threshold -> evicted", addr)
 	}
-	if v, ok := any(p).(del); ok {
-		v.Del(addr)
-		return
+}
+
+// sourceLabel addr 所属来源，作为上游指标的标签：按来源而不是按 host:port 计数，
+// 序列数不随代理的轮换、淘汰而无限增长
+func sourceLabel(p *pool.Pool, addr string) string {
+	if o, ok := p.OriginOf(addr); ok && o.Source != "" {
+		return o.Source
 	}
-	if v, ok := any(p).(ban); ok {
-		v.Ban(addr)
-		return
+	return "unknown"
+}
+
+type ctxKey int
+
+const (
+	upstreamKey ctxKey = iota // 在请求 context 中保存本次选中的上游 *url.URL
+	dialOptsKey               // 以及该上游的拨号参数 upstream.Options
+)
+
+// roundTrip 为普通 HTTP 请求选定上游并转发；失败时换其他上游重试，
+// 重试耗尽后按策略直连或返回 502。session 非空时按粘性会话选上游，失败则换绑；
+// sel 非空时只在满足标签条件的上游中选择，没有则返回 503，也不会直连。
+func (s *Server) roundTrip(req *http.Request, session string, sel selection) (*http.Response, error) {
+	defPort := 80
+	if req.URL.Scheme == "https" {
+		defPort = 443
 	}
-	if v, ok := any(p).(blacklist); ok {
-		v.Blacklist(addr)
-		return
+	action, err := s.destAction("HTTP", req.URL.Host, defPort)
+	if err != nil {
+		return nil, err
 	}
-	if v, ok := any(p).(invalidate); ok {
-		v.Invalidate(addr)
-		return
+	if action == acl.ActionDirect {
+		log.Printf("[HTTP] rule -> direct %s %s", req.Method, req.URL.String())
+		return s.tr.RoundTrip(req)
 	}
-	if v, ok := any(p).(markbad); ok {
-		v.MarkBad(addr)
-		return
+
+	opts := s.options()
+	deadline := time.Now().Add(opts.RetryTimeout)
+	attempts := opts.Retries + 1
+	if !replayable(req) {
+		attempts = 1 // 请求体只能读一次，无法安全重放
 	}
-	if v, ok := any(p).(fail); ok {
-		v.Fail(addr)
-		return
+	tried := make(map[string]struct{})
+	for i := 0; i < attempts && time.Now().Before(deadline); i++ {
+		addr, ok := s.sessions.pick(opts.Pool, session, sel.filter(tried))
+		if !ok && len(tried) == 0 {
+			if addr, err = s.noUpstream("HTTP", req.Method+" "+req.URL.String(), session, sel, sel.filter(tried)); err != nil {
+				return nil, err
+			}
+			ok = addr != ""
+		}
+		if !ok {
+			break
+		}
+		tried[addr] = struct{}{}
+		resp, err := s.roundTripVia(req, addr, deadline)
+		if err == nil {
+			return resp, nil
+		}
+		s.sessions.unpin(session, addr)
 	}
-	if v, ok := any(p).(drop); ok {
-		v.Drop(addr)
-		return
+
+	if len(sel) > 0 || !s.allowDirect(action) {
+		log.Printf("[HTTP] no usable upstream after %d tries -> 502 %s %s", len(tried), req.Method, req.URL.String())
+		return nil, &proxyError{status: http.StatusBadGateway, msg: "no usable upstream proxy"}
+	}
+	log.Printf("[HTTP] no usable upstream after %d tries -> direct %s %s", len(tried), req.Method, req.URL.String())
+	metrics.DirectFallbacks.WithLabelValues("http").Inc()
+	return s.tr.RoundTrip(req)
+}
+
+// roundTripVia 经由指定上游转发一次，结果回报给池子的健康计数；上游回 502/503/504 也算失败，
+// 请求可重放时返回错误以便换上游重试，否则把该响应原样交给客户端。
+// http 上游走代理转发（绝对 URI），https 上游对 http:// 目标同样转发、只是连接套了 TLS；
+// 其余情况（socks 上游、https:// 目标）先建隧道再由本机发 HTTP 请求。
+// deadline 只约束拿到响应头之前的阶段，不影响后续响应体的读取。
+func (s *Server) roundTripVia(req *http.Request, addr string, deadline time.Time) (*http.Response, error) {
+	p := s.options().Pool
+	u, err := upstream.Parse(addr)
+	if err != nil {
+		log.Printf("[HTTP] upstream parse error: %v (addr=%q) -> remove", err, addr)
+		removeFromPool(p, addr)
+		return nil, err
+	}
+	tr := s.tunnelTr
+	switch {
+	case u.Scheme == "http":
+		tr = s.tr
+	case u.Scheme == "https" && req.URL.Scheme == "http":
+		tr = s.tlsTr
+	}
+	log.Printf("[HTTP] %s %s upstream=%q parsed=%s|%s", req.Method, req.URL.String(), addr, u.Scheme, u.Host)
+
+	ctx := context.WithValue(req.Context(), upstreamKey, u)
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, dialOptsKey, s.dialOptions(addr)))
+	timer := time.AfterFunc(time.Until(deadline), cancel)
+	release := p.Acquire(addr)
+	done := func() {
+		release()
+		cancel()
+	}
+	start := time.Now()
+	source := sourceLabel(p, addr)
+	metrics.UpstreamRequest(source, addr, "http")
+	resp, err := tr.RoundTrip(req.WithContext(ctx))
+	timer.Stop()
+	if err != nil {
+		done()
+		log.Printf("[HTTP] upstream %q failed: %v -> report failure", addr, err)
+		metrics.UpstreamError(source, addr, "http")
+		reportFailure(p, addr)
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		_ = resp.Body.Close()
+		done()
+		log.Printf("[HTTP] upstream %q rejected credentials (%s) -> report failure", addr, resp.Status)
+		metrics.UpstreamError(source, addr, "http")
+		reportFailure(p, addr)
+		return nil, fmt.Errorf("upstream %q: %s", addr, resp.Status)
+	}
+	if gatewayError(resp.StatusCode) {
+		// 轮换型上游的后端失效时通常由上游自己回 502/503/504，按失败计入健康统计
+		log.Printf("[HTTP] upstream %q answered %s -> report failure", addr, resp.Status)
+		metrics.UpstreamError(source, addr, "http")
+		reportFailure(p, addr)
+		if replayable(req) {
+			_ = resp.Body.Close()
+			done()
+			return nil, fmt.Errorf("upstream %q: %s", addr, resp.Status)
+		}
+	} else {
+		p.ReportSuccess(addr, time.Since(start))
+	}
+	// 响应体读完/关闭后才算请求结束
+	resp.Body = &trackedBody{ReadCloser: resp.Body, release: done}
+	return resp, nil
+}
+
+// gatewayError 上游返回的网关错误：上游自身无法连到目标或后端不可用
+func gatewayError(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// replayable 请求可以换上游重发：没有请求体（请求体只能读一次）
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// connectDial 作为 goproxy 的 ConnectDialWithReq：CONNECT 请求的会话 key 取自请求头/用户名
+func (s *Server) connectDial(connReq *http.Request, network, targetAddr string) (net.Conn, error) {
+	session, sel := s.requestRoute(connReq)
+	return s.dialTarget("CONNECT", session, sel, network, targetAddr)
+}
+
+// dialTarget 为 CONNECT / SOCKS5 建立到目标的隧道：依次尝试池中不同上游，
+// 重试耗尽后按策略直连或返回 502；sel 的含义同 roundTrip。tag 仅用于日志。
+func (s *Server) dialTarget(tag, session string, sel selection, network, targetAddr string) (net.Conn, error) {
+	action, err := s.destAction(tag, targetAddr, 443)
+	if err != nil {
+		return nil, err
+	}
+	if action == acl.ActionDirect {
+		log.Printf("[%s] rule -> direct dial %s %s", tag, network, targetAddr)
+		return s.directDial(network, targetAddr)
+	}
+
+	opts := s.options()
+	deadline := time.Now().Add(opts.RetryTimeout)
+	tried := make(map[string]struct{})
+	for i := 0; i <= opts.Retries && time.Now().Before(deadline); i++ {
+		upstream, ok := s.sessions.pick(opts.Pool, session, sel.filter(tried))
+		if !ok && len(tried) == 0 {
+			if upstream, err = s.noUpstream(tag, targetAddr, session, sel, sel.filter(tried)); err != nil {
+				return nil, err
+			}
+			ok = upstream != ""
+		}
+		if !ok || strings.TrimSpace(upstream) == "" {
+			break
+		}
+		tried[upstream] = struct{}{}
+		conn, err := s.dialUpstream(tag, upstream, targetAddr, deadline)
+		if err == nil {
+			return conn, nil
+		}
+		s.sessions.unpin(session, upstream)
+	}
+
+	if len(sel) > 0 || !s.allowDirect(action) {
+		log.Printf("[%s] no usable upstream after %d tries -> 502 %s", tag, len(tried), targetAddr)
+		return nil, &proxyError{status: http.StatusBadGateway, msg: "no usable upstream proxy"}
 	}
-	log.Printf("[POOL] cannot remove %q: pool doesn't expose a remove method", addr)
+	log.Printf("[%s] no usable upstream after %d tries -> direct dial %s %s", tag, len(tried), network, targetAddr)
+	metrics.DirectFallbacks.WithLabelValues(strings.ToLower(tag)).Inc()
+	return s.directDial(network, targetAddr)
+}
+
+// directDial 不经上游直接连接目标
+func (s *Server) directDial(network, targetAddr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: s.options().DialTimeout}
+	conn, err := d.Dial(network, targetAddr)
+	if err != nil {
+		return nil, &proxyError{status: http.StatusBadGateway, msg: err.Error()}
+	}
+	tunnels := metrics.ActiveTunnels.WithLabelValues("direct")
+	tunnels.Inc()
+	return trackConn(conn, tunnels.Dec), nil
+}
+
+// dialUpstream 通过单个上游（http/https CONNECT 或 socks）建立到目标的隧道
+func (s *Server) dialUpstream(tag, addr, targetAddr string, deadline time.Time) (net.Conn, error) {
+	p := s.options().Pool
+	u, err := upstream.Parse(addr)
+	if err != nil {
+		log.Printf("[%s] upstream parse error: %v (addr=%q) -> remove", tag, err, addr)
+		removeFromPool(p, addr)
+		return nil, err
+	}
+	if u.User != nil {
+		log.Printf("[%s] try %s upstream=%s user=%q target=%s", tag, u.Scheme, u.Host, u.User.Username(), targetAddr)
+	} else {
+		log.Printf("[%s] try %s upstream=%s target=%s", tag, u.Scheme, u.Host, targetAddr)
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	kind := strings.ToLower(tag)
+	source := sourceLabel(p, addr)
+	metrics.UpstreamRequest(source, addr, kind)
+	start := time.Now()
+	release := p.Acquire(addr)
+	conn, err := upstream.Dial(ctx, u, targetAddr, s.dialOptions(addr))
+	if err != nil {
+		release()
+		log.Printf("[%s] upstream %q failed: %v -> report failure", tag, addr, err)
+		metrics.UpstreamError(source, addr, kind)
+		reportFailure(p, addr)
+		return nil, err
+	}
+	latency := time.Since(start)
+	p.ReportSuccess(addr, latency)
+	metrics.ConnectDuration.WithLabelValues(u.Scheme).Observe(latency.Seconds())
+	log.Printf("[%s] tunnel established via %s upstream", tag, u.Scheme)
+	// 隧道关闭时才释放在途计数
+	tunnels := metrics.ActiveTunnels.WithLabelValues("upstream")
+	tunnels.Inc()
+	return trackConn(conn, func() {
+		release()
+		tunnels.Dec()
+	}), nil
+}
+
+// dialTunnel 作为 tunnelTr 的 DialContext：从请求 context 取出选定的上游并建隧道。
+// tunnelTr 关闭了连接复用，保证每个请求都走自己选中的上游。
+func (s *Server) dialTunnel(ctx context.Context, network, addr string) (net.Conn, error) {
+	u, ok := ctx.Value(upstreamKey).(*url.URL)
+	if !ok {
+		d := &net.Dialer{Timeout: s.options().DialTimeout}
+		return d.DialContext(ctx, network, addr)
+	}
+	opts, _ := ctx.Value(dialOptsKey).(upstream.Options)
+	return upstream.Dial(ctx, u, addr, opts)
+}
+
+// dialTLS 作为 tlsTr 的 DialTLSContext：与请求 context 中选定的 https 上游建立 TLS 连接
+func (s *Server) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	u, ok := ctx.Value(upstreamKey).(*url.URL)
+	if !ok {
+		return nil, fmt.Errorf("no upstream selected for %s", addr)
+	}
+	opts, _ := ctx.Value(dialOptsKey).(upstream.Options)
+	return upstream.DialTLS(ctx, u, opts)
+}
+
+// dialOptions 上游拨号参数；来源配置了自己的 TLS 选项时优先使用
+func (s *Server) dialOptions(addr string) upstream.Options {
+	cur := s.options()
+	opts := upstream.Options{Timeout: cur.DialTimeout, TLS: cur.UpstreamTLS}
+	if len(cur.SourceTLS) > 0 {
+		if o, ok := cur.Pool.OriginOf(addr); ok {
+			if cfg, ok := cur.SourceTLS[o.Source]; ok {
+				opts.TLS = cfg
+			}
+		}
+	}
+	return opts
 }
 
 func New(opts Options) *Server {
 	prx := goproxy.NewProxyHttpServer()
+	s := &Server{
+		proxy:    prx,
+		sessions: newSessions(opts.SessionTTL),
+	}
+	s.opts.Store(&opts)
 
 	// 打开 goproxy 的日志
 	prx.Verbose = true
 	prx.Logger = log.New(os.Stdout, "[GOPROXY] ", log.LstdFlags|log.Lmicroseconds)
 
-	// ---------- 普通 HTTP：每请求动态选上游（仅 http） ----------
+	// ---------- 普通 HTTP：每请求动态选上游 ----------
+	// 上游在 roundTrip 中选定并放入请求 context，Transport.Proxy 只负责取出
 	tr := &http.Transport{
 		Proxy: func(req *http.Request) (*url.URL, error) {
-			if addr, ok := opts.Pool.Get(); ok {
-				u, hasScheme, err := parseUpstream(addr)
-				if err != nil || u.Host == "" {
-					log.Printf("[HTTP] upstream parse error: %v (addr=%q) -> remove & direct", err, addr)
-					removeFromPool(opts.Pool, addr)
-					return nil, nil // 走直连
-				}
-				// 只允许 http 代理
-				if !hasScheme || u.Scheme == "" {
-					u.Scheme = "http"
-				}
-				if strings.ToLower(u.Scheme) != "http" {
-					log.Printf("[HTTP] upstream scheme %q not supported; remove & direct (addr=%q)", u.Scheme, addr)
-					removeFromPool(opts.Pool, addr)
-					return nil, nil
-				}
-				log.Printf("[HTTP] %s %s upstream=%q parsed=%s|%s", req.Method, req.URL.String(), addr, u.Scheme, u.Host)
+			if u, ok := req.Context().Value(upstreamKey).(*url.URL); ok {
 				return u, nil
 			}
-			log.Printf("[HTTP] no upstream -> direct %s %s", req.Method, req.URL.String())
 			return nil, nil
 		},
 		DialContext: (&net.Dialer{
@@ -140,129 +414,83 @@ func New(opts Options) *Server {
 		TLSClientConfig:     &tls.Config{InsecureSkipVerify: false},
 	}
 	prx.Tr = tr
-
-	// ---------- HTTPS CONNECT：仅支持 http 上游代理 ----------
-	prx.ConnectDial = func(network, targetAddr string) (net.Conn, error) {
-		upstream, ok := opts.Pool.Get()
-		if !ok || strings.TrimSpace(upstream) == "" {
-			log.Printf("[CONNECT] no upstream -> direct dial %s %s", network, targetAddr)
-			d := net.Dialer{Timeout: opts.DialTimeout}
-			return d.Dial(network, targetAddr)
-		}
-		u, _, err := parseUpstream(upstream)
-		if err != nil || u.Host == "" {
-			log.Printf("[CONNECT] upstream parse error: %v (addr=%q) -> remove & direct", err, upstream)
-			removeFromPool(opts.Pool, upstream)
-			d := net.Dialer{Timeout: opts.DialTimeout}
-			return d.Dial(network, targetAddr)
-		}
-		// 仅允许 http；无 scheme 也按 http 处理
-		if u.Scheme == "" {
-			u.Scheme = "http"
-		}
-		if s := strings.ToLower(u.Scheme); s != "http" {
-			log.Printf("[CONNECT] unsupported upstream scheme=%q; remove & direct (addr=%q)", u.Scheme, upstream)
-			removeFromPool(opts.Pool, upstream)
-			d := net.Dialer{Timeout: opts.DialTimeout}
-			return d.Dial(network, targetAddr)
-		}
-
-		host := u.Host
-		if !strings.Contains(host, ":") {
-			host += ":80"
-		}
-		log.Printf("[CONNECT] try HTTP-proxy (only) upstreamHost=%s target=%s", host, targetAddr)
-
-		raw, err := net.DialTimeout("tcp", host, opts.DialTimeout)
-		if err != nil {
-			log.Printf("[CONNECT] dial upstream failed: %v -> remove & direct", err)
-			removeFromPool(opts.Pool, upstream)
-			d := net.Dialer{Timeout: opts.DialTimeout}
-			return d.Dial(network, targetAddr)
-		}
-		conn := net.Conn(raw)
-
-		// 写+读 12s 超时，避免卡死
-		_ = conn.SetDeadline(time.Now().Add(12 * time.Second))
-
-		req := &http.Request{
-			Method: "CONNECT",
-			URL:    &url.URL{Opaque: targetAddr},
-			Host:   targetAddr,
-			Header: make(http.Header),
-		}
-		if u.User != nil {
-			user := u.User.Username()
-			pass, _ := u.User.Password()
-			token := base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
-			req.Header.Set("Proxy-Authorization", "Basic "+token)
-			log.Printf("[CONNECT] using basic auth for upstream user=%q", user)
-		}
-		req.Header.Set("Proxy-Connection", "Keep-Alive")
-
-		log.Printf("[CONNECT] write CONNECT to upstream")
-		if err := req.Write(conn); err != nil {
-			_ = conn.Close()
-			log.Printf("[CONNECT] write CONNECT fail: %v -> remove & direct", err)
-			removeFromPool(opts.Pool, upstream)
-			d := net.Dialer{Timeout: opts.DialTimeout}
-			return d.Dial(network, targetAddr)
-		}
-
-		br := bufio.NewReader(conn)
-		resp, err := http.ReadResponse(br, req)
-		if err != nil {
-			_ = conn.Close()
-			log.Printf("[CONNECT] read upstream response fail: %v -> remove & direct", err)
-			removeFromPool(opts.Pool, upstream)
-			d := net.Dialer{Timeout: opts.DialTimeout}
-			return d.Dial(network, targetAddr)
-		}
-		defer func() {
-			if resp != nil && resp.Body != nil {
-				_ = resp.Body.Close()
-			}
-		}()
-
-		if resp.StatusCode != http.StatusOK {
-			_ = conn.Close()
-			log.Printf("[CONNECT] upstream CONNECT failed: %s -> remove & direct", resp.Status)
-			removeFromPool(opts.Pool, upstream)
-			d := net.Dialer{Timeout: opts.DialTimeout}
-			return d.Dial(network, targetAddr)
-		}
-
-		// 隧道建立成功后清理 deadline，交给后续长连接
-		_ = conn.SetDeadline(time.Time{})
-		log.Printf("[CONNECT] tunnel established via HTTP-proxy")
-		return conn, nil
+	s.tr = tr
+	s.tlsTr = &http.Transport{
+		Proxy:           tr.Proxy,
+		DialTLSContext:  s.dialTLS,
+		MaxIdleConns:    opts.IdleConns,
+		IdleConnTimeout: opts.IdleTimeout,
 	}
-
-	s := &Server{
-		proxy: prx,
-		opts:  opts,
+	s.tunnelTr = &http.Transport{
+		DialContext:         s.dialTunnel,
+		DisableKeepAlives:   true,
+		TLSHandshakeTimeout: opts.TLSHandshakeTimeout,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: false},
 	}
+	prx.OnRequest().DoFunc(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+		// goproxy 会在 RoundTrip 之前删掉 Proxy-Authorization，会话 key 与用户名中的筛选条件须在此提取
+		session, sel := s.requestRoute(req)
+		req.Header.Del(SessionHeader)
+		req.Header.Del(SelectHeader)
+		ctx.RoundTripper = goproxy.RoundTripperFunc(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Response, error) {
+			return s.roundTrip(req, session, sel)
+		})
+		return req, nil
+	})
+	prx.OnResponse().DoFunc(errorResponse)
+
+	// ---------- HTTPS CONNECT：经 http/https/socks 上游建隧道 ----------
+	prx.ConnectDialWithReq = s.connectDial
+	prx.ConnectionErrHandler = writeConnectError
 
 	s.httpSrv = &http.Server{
 		Addr:    opts.Listen,
-		Handler: logMiddleware(prx),
+		Handler: logMiddleware(s.authMiddleware(prx)),
 	}
 
 	return s
 }
 
+func (s *Server) options() *Options { return s.opts.Load() }
+
+func (s *Server) sourceACL() *acl.SourceACL { return s.options().SourceACL }
+
+// Update 热更新认证用户、来源 ACL、目标规则、会话、故障转移、严格模式与上游 TLS 参数，
+// 对之后的连接与请求生效，已建立的隧道不受影响。监听地址、Pool、拨号与连接池参数、OnEmpty
+// 只在 New 时生效，o 中的这些字段被忽略
+func (s *Server) Update(o Options) {
+	cur := s.options()
+	o.Listen, o.SocksListen, o.Pool, o.OnEmpty = cur.Listen, cur.SocksListen, cur.Pool, cur.OnEmpty
+	o.DialTimeout, o.IdleConns, o.IdleTimeout, o.TLSHandshakeTimeout = cur.DialTimeout, cur.IdleConns, cur.IdleTimeout, cur.TLSHandshakeTimeout
+	s.opts.Store(&o)
+	s.sessions.setTTL(o.SessionTTL)
+}
+
 // --- 简单连接日志中间件（可选） ---
 
 type loggingListener struct {
 	net.Listener
+	name     string // 监听器名称，用于日志与指标
+	acl      func() *acl.SourceACL
+	rejected *atomic.Uint64
 }
 
 func (l loggingListener) Accept() (net.Conn, error) {
-	c, err := l.Listener.Accept()
-	if err == nil {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return c, err
+		}
+		if ok, reason := l.acl().Check(c.RemoteAddr()); !ok {
+			n := l.rejected.Add(1)
+			metrics.RejectedConns.WithLabelValues(l.name, reason).Inc()
+			log.Printf("[ACL] reject from=%s reason=%s listener=%s rejected-total=%d", c.RemoteAddr(), reason, l.name, n)
+			_ = c.Close()
+			continue
+		}
 		log.Printf("[ACCEPT] from=%s -> local=%s", c.RemoteAddr(), c.LocalAddr())
+		return c, nil
 	}
-	return c, err
 }
 
 func logMiddleware(next http.Handler) http.Handler {
@@ -276,16 +504,39 @@ func logMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// Start 启动 HTTP 代理，并在配置了 SocksListen 时同时启动 SOCKS5 入站；两者共用认证、来源 ACL 与上游池
 func (s *Server) Start() error {
-	ln, err := net.Listen("tcp", s.opts.Listen)
+	opts := s.options()
+	ln, err := net.Listen("tcp", opts.Listen)
 	if err != nil {
 		log.Printf("[START] listen error: %v", err)
 		return err
 	}
-	log.Printf("[START] listening on %s", s.opts.Listen)
-	return s.httpSrv.Serve(loggingListener{ln})
+	if opts.SocksListen != "" {
+		sln, err := net.Listen("tcp", opts.SocksListen)
+		if err != nil {
+			_ = ln.Close()
+			log.Printf("[START] socks listen error: %v", err)
+			return err
+		}
+		s.mu.Lock()
+		s.socksLn = sln
+		s.mu.Unlock()
+		log.Printf("[START] socks5 listening on %s", opts.SocksListen)
+		go func() {
+			err := s.serveSocks(loggingListener{Listener: sln, name: "socks", acl: s.sourceACL, rejected: &s.rejected})
+			log.Printf("[EXIT] socks5 listener stopped: %v", err)
+		}()
+	}
+	log.Printf("[START] listening on %s", opts.Listen)
+	return s.httpSrv.Serve(loggingListener{Listener: ln, name: "http", acl: s.sourceACL, rejected: &s.rejected})
 }
 
 func (s *Server) Shutdown() error {
+	s.mu.Lock()
+	if s.socksLn != nil {
+		_ = s.socksLn.Close()
+	}
+	s.mu.Unlock()
 	return s.httpSrv.Close()
 }
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+)
+
+// 普通 http:// 请求经 https 上游时应在 TLS 连接上发送绝对 URI，而不是 CONNECT 到目标的 80 端口
+func TestRoundTripViaHTTPSUpstream(t *testing.T) {
+	var method, uri, auth string
+	up := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, uri, auth = r.Method, r.RequestURI, r.Header.Get("Proxy-Authorization")
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer up.Close()
+
+	addr := "https://u:p@" + up.Listener.Addr().String()
+	p := pool.New(pool.Options{FailThreshold: 1})
+	p.AddFrom(addr, time.Minute, pool.Origin{Source: "test"})
+	s := New(Options{
+		Pool:        p,
+		DialTimeout: time.Second,
+		UpstreamTLS: up.Client().Transport.(*http.Transport).TLSClientConfig,
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://target.example/path?q=1", nil)
+	resp, err := s.roundTripVia(req, addr, time.Now().Add(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if method != http.MethodGet || uri != "http://target.example/path?q=1" || string(body) != "ok" {
+		t.Fatalf("upstream got %s %s (body %q), want GET with absolute URI", method, uri, body)
+	}
+	if auth != "Basic dTpw" {
+		t.Fatalf("Proxy-Authorization = %q, want Basic dTpw", auth)
+	}
+}
+
+// 上游自己回 502/503/504 时计为失败：可重放的请求换上游重试，带请求体的请求原样返回该响应
+func TestRoundTripUpstreamGatewayError(t *testing.T) {
+	var hits atomic.Uint64
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "backend down", http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer good.Close()
+	badAddr, goodAddr := "http://"+bad.Listener.Addr().String(), "http://"+good.Listener.Addr().String()
+
+	tests := []struct {
+		name     string
+		upstream []string
+		body     string
+		status   int // 0 表示期望错误
+	}{
+		{"retry on another upstream", []string{badAddr, goodAddr}, "", http.StatusOK},
+		{"no upstream left", []string{badAddr}, "", 0},
+		{"body is not replayed", []string{badAddr}, "payload", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits.Store(0)
+			p := pool.New(pool.Options{FailThreshold: 100})
+			for _, addr := range tt.upstream {
+				p.AddFrom(addr, time.Minute, pool.Origin{Source: "test"})
+			}
+			s := New(Options{Pool: p, Retries: 2, RetryTimeout: 5 * time.Second, DialTimeout: time.Second})
+			// 轮询选择下，多次请求中坏上游至少会被先选中一次
+			for i := 0; i < 4; i++ {
+				var body io.Reader
+				if tt.body != "" {
+					body = strings.NewReader(tt.body)
+				}
+				req, _ := http.NewRequest(http.MethodPost, "http://target.example/", body)
+				resp, err := s.roundTrip(req, "", nil)
+				if tt.status == 0 {
+					if err == nil {
+						t.Fatalf("roundTrip status %d, want error", resp.StatusCode)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				_ = resp.Body.Close()
+				if resp.StatusCode != tt.status {
+					t.Fatalf("roundTrip status %d, want %d", resp.StatusCode, tt.status)
+				}
+			}
+			for _, in := range p.List() {
+				if in.Addr != badAddr {
+					continue
+				}
+				if n := hits.Load(); n == 0 || in.Failure != n || in.Success != 0 {
+					t.Fatalf("bad upstream hit %d times: failures %d, successes %d", n, in.Failure, in.Success)
+				}
+			}
+		})
+	}
+}
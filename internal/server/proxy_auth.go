@@ -0,0 +1,36 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// authMiddleware 校验入站 Proxy-Authorization: Basic，CONNECT 与普通 HTTP 请求一视同仁；
+// 未配置用户时直接放行。失败返回 407 并携带 Proxy-Authenticate 质询。
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts := s.options()
+		if opts.Users == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, pass, ok := proxyAuth(r)
+		if ok && checkUser(opts, user, pass) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		log.Printf("[AUTH] reject %s %s From=%s user=%q", r.Method, r.Host, r.RemoteAddr, user)
+		w.Header().Set("Proxy-Authenticate", "Basic realm="+strconv.Quote(opts.AuthRealm))
+		http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
+	})
+}
+
+// checkUser 先按完整用户名校验；不存在时再去掉 "-session-xxx" 这类参数后缀按基础用户名校验
+func checkUser(opts *Options, user, pass string) bool {
+	if opts.Users.Check(user, pass) {
+		return true
+	}
+	name, params := parseUsername(user, opts.paramKey)
+	return params != nil && opts.Users.Check(name, pass)
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/lianshufeng/proxy-pool/internal/acl"
+)
+
+// destAction 对目标 host:port 求值目标规则；hostport 不带端口时使用 defPort
+func (s *Server) destAction(tag, hostport string, defPort int) (acl.Action, error) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, portStr = hostport, strconv.Itoa(defPort)
+	}
+	port, _ := strconv.Atoi(portStr)
+	action, rule := s.options().Rules.Match(host, port)
+	if rule == nil {
+		return acl.ActionNone, nil
+	}
+	log.Printf("[%s] target=%s matched rule %q -> %s", tag, hostport, rule, action)
+	if action == acl.ActionBlock {
+		return action, &proxyError{status: http.StatusForbidden, msg: "destination blocked by rule: " + rule.String()}
+	}
+	return action, nil
+}
+
+// allowDirect 重试耗尽后能否直连：需开启 --allow-direct，且目标未被要求必须走池子
+func (s *Server) allowDirect(action acl.Action) bool {
+	return s.options().AllowDirect && action != acl.ActionPool
+}
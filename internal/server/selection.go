@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+)
+
+// SelectHeader 客户端通过该请求头按标签筛选上游，如 "country=us,type=residential"；转发前会被移除
+const SelectHeader = "X-Proxy-Pool-Select"
+
+// selection 标签筛选条件：标签名 -> 期望值（"|" 分隔多个候选）；空表示不筛选
+type selection map[string]string
+
+// DefaultSelectKeys 用户名中始终可作为标签筛选的参数名；其余参数名需由来源的标签映射声明
+var DefaultSelectKeys = []string{"country", "city", "isp", "region"}
+
+// requestSelection 合并用户名参数（如 "user-country-us"）与 X-Proxy-Pool-Select 请求头，同名时请求头优先
+func requestSelection(r *http.Request, params map[string]string) selection {
+	sel := userSelection(params)
+	for _, kv := range strings.Split(r.Header.Get(SelectHeader), ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			continue
+		}
+		if sel == nil {
+			sel = make(selection)
+		}
+		sel[k] = v
+	}
+	return sel
+}
+
+// userSelection 用户名中除 session 外的参数都作为标签筛选条件；SOCKS5 入站直接使用它
+func userSelection(params map[string]string) selection {
+	var sel selection
+	for k, v := range params {
+		if k == "session" || v == "" {
+			continue
+		}
+		if sel == nil {
+			sel = make(selection)
+		}
+		sel[k] = v
+	}
+	return sel
+}
+
+// parseUser 拆分用户名中的会话与筛选参数。完整用户名本身就是已配置的用户时不拆分，
+// 参数名只接受 session、DefaultSelectKeys 与 Options.SelectKeys，避免把 "svc-scraper-01" 这类用户名误当成参数
+func (s *Server) parseUser(user string) (name string, params map[string]string) {
+	opts := s.options()
+	if user == "" || opts.Users.Has(user) {
+		return user, nil
+	}
+	return parseUsername(user, opts.paramKey)
+}
+
+// paramKey 用户名中可用的参数名
+func (o *Options) paramKey(key string) bool {
+	return key == "session" || slices.Contains(DefaultSelectKeys, key) || slices.Contains(o.SelectKeys, key)
+}
+
+// filter 排除已尝试的上游，并只保留满足筛选条件的
+func (sel selection) filter(tried map[string]struct{}) pool.Filter {
+	ex := excluding(tried)
+	if len(sel) == 0 {
+		return ex
+	}
+	match := pool.MatchLabels(sel)
+	return func(pr *pool.Proxy) bool { return ex(pr) && match(pr) }
+}
+
+// String 按标签名排序输出，便于日志
+func (sel selection) String() string {
+	parts := make([]string, 0, len(sel))
+	for k, v := range sel {
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// errNoMatch 池中没有满足筛选条件的上游
+func errNoMatch(sel selection) *proxyError {
+	return &proxyError{
+		status: http.StatusServiceUnavailable,
+		reason: "No Matching Upstream",
+		msg:    fmt.Sprintf("no upstream proxy matches %s", sel),
+	}
+}
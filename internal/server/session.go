@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+)
+
+// SessionHeader 客户端通过该请求头指定粘性会话；转发前会被移除
+const SessionHeader = "X-Proxy-Session"
+
+// sessions 粘性会话表：会话 key -> 固定的上游。
+// 每次命中都会顺延过期时间；上游失效/失败时自动换绑。
+type sessions struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	m         map[string]*session
+	lastSweep time.Time
+}
+
+type session struct {
+	upstream string
+	expireAt time.Time
+}
+
+func newSessions(ttl time.Duration) *sessions {
+	return &sessions{ttl: ttl, m: make(map[string]*session)}
+}
+
+// pick 返回 key 绑定的上游；未绑定、已不可用或不满足 f 时从池中重新挑选并绑定。
+// key 为空或会话功能关闭时等价于 p.Pick(f)。
+func (s *sessions) pick(p *pool.Pool, key string, f pool.Filter) (string, bool) {
+	if key == "" {
+		return p.Pick(f)
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ttl <= 0 {
+		return p.Pick(f)
+	}
+
+	s.sweepLocked(now)
+	if ss, ok := s.m[key]; ok && now.Before(ss.expireAt) && p.Available(ss.upstream, f) {
+		ss.expireAt = now.Add(s.ttl)
+		return ss.upstream, true
+	}
+	addr, ok := p.Pick(f)
+	if !ok {
+		delete(s.m, key)
+		return "", false
+	}
+	s.m[key] = &session{upstream: addr, expireAt: now.Add(s.ttl)}
+	return addr, true
+}
+
+// setTTL 修改会话空闲过期时长；<=0 时关闭会话保持并清空已有绑定
+func (s *sessions) setTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttl = ttl
+	if ttl <= 0 {
+		clear(s.m)
+	}
+}
+
+// unpin 上游失败后解除绑定，下一次 pick 会换绑新的上游
+func (s *sessions) unpin(key, upstream string) {
+	if key == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ss, ok := s.m[key]; ok && ss.upstream == upstream {
+		delete(s.m, key)
+	}
+}
+
+// sweepLocked 最多每个 ttl 清理一次过期会话；调用方需持锁
+func (s *sessions) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < s.ttl {
+		return
+	}
+	s.lastSweep = now
+	for k, ss := range s.m {
+		if !now.Before(ss.expireAt) {
+			delete(s.m, k)
+		}
+	}
+}
+
+// requestRoute 从请求中提取会话 key 与标签筛选条件
+func (s *Server) requestRoute(r *http.Request) (session string, sel selection) {
+	user, _, _ := proxyAuth(r)
+	name, params := s.parseUser(user)
+	return sessionKey(r, name, params, s.options().SessionByIP), requestSelection(r, params)
+}
+
+// sessionKey 按优先级提取会话 key：
+//  1. X-Proxy-Session 请求头
+//  2. Proxy-Authorization 用户名参数，如 "user-session-abc"
+//  3. 客户端 IP（需开启 byIP）
+func sessionKey(r *http.Request, name string, params map[string]string, byIP bool) string {
+	if v := strings.TrimSpace(r.Header.Get(SessionHeader)); v != "" {
+		return "h:" + v
+	}
+	return userSessionKey(name, params, r.RemoteAddr, byIP)
+}
+
+// userSessionKey 从用户名参数或客户端地址提取会话 key；SOCKS5 入站没有请求头，直接使用它
+func userSessionKey(name string, params map[string]string, remoteAddr string, byIP bool) string {
+	if params["session"] != "" {
+		return "u:" + name + "/" + params["session"]
+	}
+	if byIP {
+		if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+			return "ip:" + host
+		}
+	}
+	return ""
+}
+
+// proxyAuth 解析 Proxy-Authorization: Basic 中的用户名与密码
+func proxyAuth(r *http.Request) (user, pass string, ok bool) {
+	h := r.Header.Get("Proxy-Authorization")
+	const prefix = "Basic "
+	if len(h) < len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(h[len(prefix):]))
+	if err != nil {
+		return "", "", false
+	}
+	user, pass, _ = strings.Cut(string(raw), ":")
+	return user, pass, user != ""
+}
+
+// parseUsername 解析 "name-key1-val1-key2-val2" 形式的用户名；
+// 段数不成对、或任一参数名不被 known 接受时视为普通用户名（如 "svc-scraper-01"），不带参数。
+func parseUsername(user string, known func(key string) bool) (name string, params map[string]string) {
+	parts := strings.Split(user, "-")
+	if len(parts) < 3 || len(parts)%2 == 0 {
+		return user, nil
+	}
+	params = make(map[string]string, len(parts)/2)
+	for i := 1; i+1 < len(parts); i += 2 {
+		key := strings.ToLower(parts[i])
+		if !known(key) {
+			return user, nil
+		}
+		params[key] = parts[i+1]
+	}
+	return parts[0], params
+}
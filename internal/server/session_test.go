@@ -0,0 +1,196 @@
+package server
+
+import (
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/auth"
+	"github.com/lianshufeng/proxy-pool/internal/pool"
+)
+
+func TestParseUsername(t *testing.T) {
+	opts := &Options{SelectKeys: []string{"type"}}
+	tests := []struct {
+		user   string
+		name   string
+		params map[string]string
+	}{
+		{"alice", "alice", nil},
+		{"alice-session-abc", "alice", map[string]string{"session": "abc"}},
+		{"alice-Country-us-session-abc", "alice", map[string]string{"country": "us", "session": "abc"}},
+		{"alice-type-residential", "alice", map[string]string{"type": "residential"}},
+		{"alice-country-us|gb", "alice", map[string]string{"country": "us|gb"}},
+		// 参数名不在已知集合中：整体是普通用户名
+		{"svc-scraper-01", "svc-scraper-01", nil},
+		{"alice-country-us-scraper-01", "alice-country-us-scraper-01", nil},
+		// 段数不成对
+		{"alice-session", "alice-session", nil},
+		{"alice-session-abc-x", "alice-session-abc-x", nil},
+		{"a-b", "a-b", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			name, params := parseUsername(tt.user, opts.paramKey)
+			if name != tt.name || !maps.Equal(params, tt.params) {
+				t.Fatalf("parseUsername(%q) = %q %v, want %q %v", tt.user, name, params, tt.name, tt.params)
+			}
+		})
+	}
+}
+
+func TestParseUserConfiguredName(t *testing.T) {
+	users, err := auth.Load("", []string{"alice-country-us:pw", "bob:pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{}
+	s.opts.Store(&Options{Users: users})
+
+	tests := []struct {
+		user   string
+		name   string
+		params map[string]string
+	}{
+		// 完整用户名就是已配置的用户：不拆分
+		{"alice-country-us", "alice-country-us", nil},
+		{"bob-country-us", "bob", map[string]string{"country": "us"}},
+		{"", "", nil},
+	}
+	for _, tt := range tests {
+		name, params := s.parseUser(tt.user)
+		if name != tt.name || !maps.Equal(params, tt.params) {
+			t.Errorf("parseUser(%q) = %q %v, want %q %v", tt.user, name, params, tt.name, tt.params)
+		}
+		if sel := userSelection(params); len(sel) != len(tt.params) {
+			t.Errorf("userSelection(%v) = %v", params, sel)
+		}
+	}
+}
+
+func TestCheckUser(t *testing.T) {
+	users, err := auth.Load("", []string{"alice:pw", "svc-scraper-01:pw2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{Users: users}
+	tests := []struct {
+		user, pass string
+		want       bool
+	}{
+		{"alice", "pw", true},
+		{"alice-session-abc", "pw", true},
+		{"alice-country-us", "pw", true},
+		{"alice-session-abc", "bad", false},
+		{"alice-foo-bar", "pw", false},
+		{"svc-scraper-01", "pw2", true},
+		{"svc-scraper-01-session-x", "pw2", false},
+		{"nobody", "pw", false},
+	}
+	for _, tt := range tests {
+		if got := checkUser(opts, tt.user, tt.pass); got != tt.want {
+			t.Errorf("checkUser(%q, %q) = %v, want %v", tt.user, tt.pass, got, tt.want)
+		}
+	}
+}
+
+func TestSessionsPick(t *testing.T) {
+	p := pool.New(pool.Options{})
+	for _, addr := range []string{"http://a:1", "http://b:1", "http://c:1"} {
+		p.AddFrom(addr, time.Hour, pool.Origin{Source: addr})
+	}
+	s := newSessions(time.Hour)
+	pick := func(key string, f pool.Filter) string {
+		t.Helper()
+		addr, ok := s.pick(p, key, f)
+		if !ok {
+			t.Fatalf("pick(%q) found no upstream", key)
+		}
+		return addr
+	}
+
+	// 同一会话固定在一个上游；没有会话时按轮询换上游
+	first := pick("k", nil)
+	for i := 0; i < 5; i++ {
+		if got := pick("k", nil); got != first {
+			t.Fatalf("pick #%d = %s, want pinned %s", i, got, first)
+		}
+	}
+	if pick("", nil) == pick("", nil) {
+		t.Fatal("picks without a session did not rotate")
+	}
+
+	// 别的上游失败不影响绑定；绑定的上游失败后换绑且不再选它
+	s.unpin("k", "http://other:1")
+	s.unpin("", first)
+	if got := pick("k", nil); got != first {
+		t.Fatalf("pick after unrelated unpin = %s, want %s", got, first)
+	}
+	s.unpin("k", first)
+	notFirst := func(pr *pool.Proxy) bool { return pr.Addr != first }
+	second := pick("k", notFirst)
+	if second == first {
+		t.Fatalf("re-pin after failure chose the failed upstream %s", first)
+	}
+	if got := pick("k", nil); got != second {
+		t.Fatalf("pick after re-pin = %s, want %s", got, second)
+	}
+
+	// 绑定的上游不满足筛选条件或已离开池子时换绑
+	third := pick("k", func(pr *pool.Proxy) bool { return pr.Addr != first && pr.Addr != second })
+	if third == first || third == second {
+		t.Fatalf("pick with filter = %s", third)
+	}
+	p.Remove(third)
+	if got := pick("k", nil); got == third {
+		t.Fatalf("pick kept upstream %s removed from the pool", third)
+	}
+
+	// 池子空了：pick 失败并清掉绑定
+	p.Drain()
+	if _, ok := s.pick(p, "k", nil); ok {
+		t.Fatal("pick on an empty pool succeeded")
+	}
+	if _, ok := s.m["k"]; ok {
+		t.Fatal("binding kept after the pool ran empty")
+	}
+}
+
+func TestSessionsTTL(t *testing.T) {
+	p := pool.New(pool.Options{})
+	p.AddFrom("http://a:1", time.Hour, pool.Origin{})
+	p.AddFrom("http://b:1", time.Hour, pool.Origin{})
+	ttl := 100 * time.Millisecond
+	s := newSessions(ttl)
+
+	// 每次命中顺延过期时间：总时长超过 ttl 仍是同一个绑定
+	s.pick(p, "k", nil)
+	pinned := s.m["k"]
+	for i := 0; i < 4; i++ {
+		time.Sleep(ttl / 2)
+		s.pick(p, "k", nil)
+		if s.m["k"] != pinned {
+			t.Fatalf("binding replaced after %d picks within ttl", i+1)
+		}
+	}
+
+	// 空闲超过 ttl 后重新绑定，其他过期会话被清理
+	s.pick(p, "idle", nil)
+	time.Sleep(ttl + 20*time.Millisecond)
+	s.pick(p, "k", nil)
+	if s.m["k"] == pinned {
+		t.Fatal("expired binding reused")
+	}
+	if _, ok := s.m["idle"]; ok {
+		t.Fatal("expired session not swept")
+	}
+
+	// ttl <= 0 关闭会话保持并清空绑定
+	s.setTTL(0)
+	if len(s.m) != 0 {
+		t.Fatalf("bindings kept after setTTL(0): %d", len(s.m))
+	}
+	if _, ok := s.pick(p, "k", nil); !ok || len(s.m) != 0 {
+		t.Fatalf("pick with sessions off stored a binding: %d", len(s.m))
+	}
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SOCKS5 入站（RFC 1928）与用户名/密码认证（RFC 1929）
+const (
+	socks5Version      = 0x05
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoMatch  = 0xff
+	socks5CmdConnect   = 0x01
+	socks5AtypIPv4     = 0x01
+	socks5AtypDomain   = 0x03
+	socks5AtypIPv6     = 0x04
+
+	socks5RepSuccess        = 0x00
+	socks5RepFailure        = 0x01
+	socks5RepNotAllowed     = 0x02
+	socks5RepHostUnreach    = 0x04
+	socks5RepCmdUnsupported = 0x07
+	socks5RepAtypUnsupport  = 0x08
+
+	// socks5NegotiateTimeout 从接入到收到 CONNECT 请求的最长时间
+	socks5NegotiateTimeout = 30 * time.Second
+)
+
+// serveSocks 在 ln 上接受 SOCKS5 客户端，直到 ln 关闭
+func (s *Server) serveSocks(ln net.Listener) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleSocks(c)
+	}
+}
+
+// handleSocks 处理单个 SOCKS5 连接：协商认证、读取请求，
+// CONNECT 与 HTTP CONNECT 共用上游选择、会话与故障转移逻辑；BIND / UDP ASSOCIATE 回复不支持。
+func (s *Server) handleSocks(c net.Conn) {
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(socks5NegotiateTimeout))
+	br := bufio.NewReader(c)
+
+	user, err := s.socksNegotiate(br, c)
+	if err != nil {
+		log.Printf("[SOCKS] negotiate from=%s: %v", c.RemoteAddr(), err)
+		return
+	}
+
+	var hdr [3]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		log.Printf("[SOCKS] read request from=%s: %v", c.RemoteAddr(), err)
+		return
+	}
+	if hdr[0] != socks5Version {
+		log.Printf("[SOCKS] bad request version %d from=%s", hdr[0], c.RemoteAddr())
+		return
+	}
+	target, err := readSocksAddr(br)
+	if err != nil {
+		log.Printf("[SOCKS] read target from=%s: %v", c.RemoteAddr(), err)
+		if errors.Is(err, errSocksAtyp) {
+			writeSocksReply(c, socks5RepAtypUnsupport)
+		}
+		return
+	}
+	if hdr[1] != socks5CmdConnect {
+		log.Printf("[SOCKS] command %d not supported from=%s target=%s", hdr[1], c.RemoteAddr(), target)
+		writeSocksReply(c, socks5RepCmdUnsupported)
+		return
+	}
+	log.Printf("[IN] SOCKS5 CONNECT %s From=%s user=%q", target, c.RemoteAddr(), user)
+
+	name, params := s.parseUser(user)
+	session := userSessionKey(name, params, c.RemoteAddr().String(), s.options().SessionByIP)
+	up, err := s.dialTarget("SOCKS", session, userSelection(params), "tcp", target)
+	if err != nil {
+		writeSocksReply(c, socksReplyCode(err))
+		return
+	}
+	defer up.Close()
+	if err := writeSocksReply(c, socks5RepSuccess); err != nil {
+		return
+	}
+	_ = c.SetDeadline(time.Time{})
+
+	// 客户端可能在收到应答前就发出了数据，先把缓冲中的部分转给上游
+	if n := br.Buffered(); n > 0 {
+		b, _ := br.Peek(n)
+		if _, err := up.Write(b); err != nil {
+			return
+		}
+	}
+	relay(c, up, s.options().IdleTimeout)
+}
+
+// socksNegotiate 协商认证方式；配置了用户时只接受用户名/密码认证，返回客户端用户名。
+// 未配置用户时若客户端提供了用户名/密码认证也选用它但不校验，以便用户名携带会话与筛选参数。
+func (s *Server) socksNegotiate(br *bufio.Reader, c net.Conn) (string, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return "", err
+	}
+	if hdr[0] != socks5Version {
+		return "", fmt.Errorf("unexpected version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return "", err
+	}
+	offered := func(want byte) bool {
+		for _, m := range methods {
+			if m == want {
+				return true
+			}
+		}
+		return false
+	}
+	opts := s.options() // 握手期间热更新不影响本次校验
+	users := opts.Users
+	want := byte(socks5AuthNone)
+	if users != nil || offered(socks5AuthPassword) {
+		want = socks5AuthPassword
+	}
+	if !offered(want) {
+		_, _ = c.Write([]byte{socks5Version, socks5AuthNoMatch})
+		return "", fmt.Errorf("no acceptable auth method in %v", methods)
+	}
+	if _, err := c.Write([]byte{socks5Version, want}); err != nil {
+		return "", err
+	}
+	if want == socks5AuthNone {
+		return "", nil
+	}
+
+	// RFC 1929: VER(1) ULEN(1) UNAME PLEN(1) PASSWD
+	ver, err := br.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	user, err := readSocksString(br)
+	if err != nil {
+		return "", err
+	}
+	pass, err := readSocksString(br)
+	if err != nil {
+		return "", err
+	}
+	if ver != 0x01 || (users != nil && !checkUser(opts, user, pass)) {
+		_, _ = c.Write([]byte{0x01, 0x01})
+		log.Printf("[AUTH] reject SOCKS5 From=%s user=%q", c.RemoteAddr(), user)
+		return "", errors.New("authentication failed")
+	}
+	if _, err := c.Write([]byte{0x01, 0x00}); err != nil {
+		return "", err
+	}
+	return user, nil
+}
+
+var errSocksAtyp = errors.New("address type not supported")
+
+// readSocksAddr 读取 ATYP + 地址 + 端口，返回 host:port；域名原样保留，交给上游解析
+func readSocksAddr(br *bufio.Reader) (string, error) {
+	atyp, err := br.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	var host string
+	switch atyp {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		n := net.IPv4len
+		if atyp == socks5AtypIPv6 {
+			n = net.IPv6len
+		}
+		ip := make(net.IP, n)
+		if _, err := io.ReadFull(br, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5AtypDomain:
+		if host, err = readSocksString(br); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("%w: %d", errSocksAtyp, atyp)
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(br, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// readSocksString 读取一个长度前缀（1 字节）的字符串
+func readSocksString(br *bufio.Reader) (string, error) {
+	n, err := br.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// writeSocksReply 回写应答；绑定地址固定为 0.0.0.0:0，隧道经上游建立，本地地址对客户端没有意义
+func writeSocksReply(c net.Conn, rep byte) error {
+	_, err := c.Write([]byte{socks5Version, rep, 0x00, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// socksReplyCode 把 dialTarget 的错误映射为 SOCKS5 应答码
+func socksReplyCode(err error) byte {
+	var pe *proxyError
+	if !errors.As(err, &pe) {
+		return socks5RepHostUnreach
+	}
+	switch pe.status {
+	case http.StatusForbidden:
+		return socks5RepNotAllowed
+	case http.StatusBadGateway:
+		return socks5RepHostUnreach
+	}
+	return socks5RepFailure
+}
+
+// relay 双向转发直到两个方向都结束；支持半关闭时先关写端，让对端读到 EOF。
+// idle > 0 时任一方向有数据都顺延两端的读写超时，两个方向都静默超过 idle 则关闭隧道。
+func relay(a, b net.Conn, idle time.Duration) {
+	extend := func() {}
+	if idle > 0 {
+		extend = func() {
+			t := time.Now().Add(idle)
+			_ = a.SetDeadline(t)
+			_ = b.SetDeadline(t)
+		}
+		extend()
+	}
+	var closeIdle sync.Once
+	done := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn) {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				extend()
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					err = werr
+				}
+			}
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					closeIdle.Do(func() {
+						log.Printf("[SOCKS] tunnel %s <-> %s idle for %s -> close", a.RemoteAddr(), b.RemoteAddr(), idle)
+						_ = a.Close()
+						_ = b.Close()
+					})
+				}
+				break
+			}
+		}
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go cp(a, b)
+	go cp(b, a)
+	<-done
+	<-done
+}
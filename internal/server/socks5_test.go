@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lianshufeng/proxy-pool/internal/auth"
+)
+
+// recordConn 记录 socksNegotiate 写给客户端的应答
+type recordConn struct {
+	net.Conn
+	out bytes.Buffer
+}
+
+func (c *recordConn) Write(b []byte) (int, error) { return c.out.Write(b) }
+func (c *recordConn) RemoteAddr() net.Addr        { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+func socksAuth(user, pass string) string {
+	return "\x01" + string([]byte{byte(len(user))}) + user + string([]byte{byte(len(pass))}) + pass
+}
+
+func TestSocksNegotiate(t *testing.T) {
+	users, err := auth.Load("", []string{"alice:secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		users   *auth.Users
+		in      string
+		user    string
+		reply   string
+		wantErr string
+	}{
+		{"no auth", nil, "\x05\x01\x00", "", "\x05\x00", ""},
+		{"params without users", nil, "\x05\x02\x00\x02" + socksAuth("bob-session-1", "x"), "bob-session-1", "\x05\x02\x01\x00", ""},
+		{"password", users, "\x05\x02\x00\x02" + socksAuth("alice", "secret"), "alice", "\x05\x02\x01\x00", ""},
+		{"password with params", users, "\x05\x01\x02" + socksAuth("alice-country-us", "secret"), "alice-country-us", "\x05\x02\x01\x00", ""},
+		{"wrong password", users, "\x05\x01\x02" + socksAuth("alice", "nope"), "", "\x05\x02\x01\x01", "authentication failed"},
+		{"unknown user", users, "\x05\x01\x02" + socksAuth("mallory", "secret"), "", "\x05\x02\x01\x01", "authentication failed"},
+		{"bad auth version", users, "\x05\x01\x02\x02" + socksAuth("alice", "secret")[1:], "", "\x05\x02\x01\x01", "authentication failed"},
+		{"users need password", users, "\x05\x01\x00", "", "\x05\xff", "no acceptable auth method"},
+		{"bad version", nil, "\x04\x01\x00", "", "", "unexpected version"},
+		{"truncated", nil, "\x05\x02\x00", "", "", "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{}
+			s.opts.Store(&Options{Users: tt.users})
+			c := &recordConn{}
+			user, err := s.socksNegotiate(bufio.NewReader(strings.NewReader(tt.in)), c)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("socksNegotiate error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("socksNegotiate: %v", err)
+			}
+			if user != tt.user || c.out.String() != tt.reply {
+				t.Fatalf("socksNegotiate = %q, reply %q; want %q, reply %q", user, c.out.Bytes(), tt.user, tt.reply)
+			}
+		})
+	}
+}
+
+func TestReadSocksAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+		err  error
+	}{
+		{"ipv4", "\x01\x01\x02\x03\x04\x00\x50", "1.2.3.4:80", nil},
+		{"domain", "\x03\x0bexample.com\x01\xbb", "example.com:443", nil},
+		{"ipv6", "\x04\x20\x01\x0d\xb8" + strings.Repeat("\x00", 11) + "\x01\x01\xbb", "[2001:db8::1]:443", nil},
+		{"unsupported atyp", "\x05\x00", "", errSocksAtyp},
+		{"truncated ipv6", "\x04\x20\x01", "", nil},
+		{"truncated port", "\x03\x01a\x00", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readSocksAddr(bufio.NewReader(strings.NewReader(tt.in)))
+			if tt.want == "" {
+				if err == nil || tt.err != nil && !errors.Is(err, tt.err) {
+					t.Fatalf("readSocksAddr = %q, %v; want error %v", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("readSocksAddr = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelayIdleTimeout(t *testing.T) {
+	client, a := net.Pipe()
+	b, up := net.Pipe()
+	defer client.Close()
+	defer up.Close()
+	idle := 100 * time.Millisecond
+	done := make(chan struct{})
+	go func() {
+		relay(a, b, idle)
+		close(done)
+	}()
+
+	// 持续有数据时超过 idle 也不关闭，两个方向交替
+	buf := make([]byte, 4)
+	for i := 0; i < 6; i++ {
+		src, dst := client, up
+		if i%2 == 1 {
+			src, dst = up, client
+		}
+		time.Sleep(idle / 2)
+		go src.Write([]byte("ping"))
+		if _, err := io.ReadFull(dst, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("round %d: read %q, %v", i, buf, err)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * idle):
+		t.Fatal("relay not closed after idle timeout")
+	}
+	if _, err := client.Read(buf); err == nil {
+		t.Fatal("client side still open after idle timeout")
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// trackedBody 在响应体关闭时释放上游的在途计数
+type trackedBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// trackedConn 在隧道连接关闭时释放上游的在途计数
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// trackedTCPConn 额外暴露半关闭能力，使 goproxy 仍走 TCP 半关闭的转发分支
+type trackedTCPConn struct {
+	*trackedConn
+	tcp *net.TCPConn
+}
+
+func (c *trackedTCPConn) CloseRead() error  { return c.tcp.CloseRead() }
+func (c *trackedTCPConn) CloseWrite() error { return c.tcp.CloseWrite() }
+
+func trackConn(c net.Conn, release func()) net.Conn {
+	t := &trackedConn{Conn: c, release: release}
+	if tcp, ok := c.(*net.TCPConn); ok {
+		return &trackedTCPConn{trackedConn: t, tcp: tcp}
+	}
+	return t
+}
@@ -1,89 +1,54 @@
-// placeholder httpchain.go
 package upstream
 
 import (
 	"bufio"
-	"context"
+	"encoding/base64"
 	"fmt"
-	"io"
 	"net"
+	"net/http"
 	"net/url"
-	"strings"
-	"time"
 )
 
-func parseUpstream(addr string) (*url.URL, error) {
-	if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
-		return url.Parse(addr)
+// connectHandshake 向 HTTP 上游发送 CONNECT 并等待 200。
+// 服务端先发言的协议（SSH、SMTP 等）可能让首批隧道数据与 200 一起到达，
+// 此时返回带缓冲的连接以免丢数据；否则原样返回 conn。
+func connectHandshake(conn net.Conn, u *url.URL, target string) (net.Conn, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
 	}
-	return url.Parse("http://" + addr)
-}
-
-// 通过“上游 HTTP 代理”建立到 target 的 CONNECT 隧道
-func DialViaHTTPProxy(ctx context.Context, upstreamAddr, target string, timeout time.Duration) (net.Conn, error) {
-	u, err := parseUpstream(upstreamAddr)
-	if err != nil || u.Host == "" {
-		return nil, fmt.Errorf("bad upstream: %v", err)
-	}
-	d := &net.Dialer{Timeout: timeout}
-	upConn, err := d.DialContext(ctx, "tcp", u.Host)
-	if err != nil {
-		return nil, err
+	if u.User != nil {
+		user := u.User.Username()
+		pass, _ := u.User.Password()
+		token := base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+token)
 	}
+	req.Header.Set("Proxy-Connection", "Keep-Alive")
 
-	// 写 CONNECT
-	var b strings.Builder
-	fmt.Fprintf(&b, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n", target, target)
-	if u.User != nil {
-		if pw, ok := u.User.Password(); ok {
-			creds := u.User.Username() + ":" + pw
-			auth := "Basic " + base64Std(creds)
-			fmt.Fprintf(&b, "Proxy-Authorization: %s\r\n", auth)
-		}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("write CONNECT: %w", err)
 	}
-	b.WriteString("\r\n")
-	if _, err = io.WriteString(upConn, b.String()); err != nil {
-		upConn.Close()
-		return nil, err
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("read CONNECT response: %w", err)
 	}
-	// 读应答
-	br := bufio.NewReader(upConn)
-	line, err := br.ReadString('\n')
-	if err != nil || !strings.Contains(line, "200") {
-		upConn.Close()
-		if err == nil {
-			err = fmt.Errorf("CONNECT failed: %q", strings.TrimSpace(line))
-		}
-		return nil, err
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream CONNECT failed: %s", resp.Status)
 	}
-	// 扔掉头
-	for {
-		h, _ := br.ReadString('\n')
-		if h == "\r\n" || h == "\n" || h == "" {
-			break
-		}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
 	}
-	return upConn, nil
+	return conn, nil
 }
 
-func base64Std(s string) string {
-	const enc = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
-	var out strings.Builder
-	var val uint
-	var valb int
-	for i := 0; i < len(s); i++ {
-		val = (val << 8) | uint(s[i])
-		valb += 8
-		for valb >= 6 {
-			out.WriteByte(enc[(val>>(uint(valb-6)))&0x3F])
-			valb -= 6
-		}
-	}
-	if valb > 0 {
-		out.WriteByte(enc[(val<<(uint(6-valb)))&0x3F])
-	}
-	for out.Len()%4 != 0 {
-		out.WriteByte('=')
-	}
-	return out.String()
+// bufferedConn 先读完握手时多读进缓冲区的数据，再读底层连接
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
 }
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
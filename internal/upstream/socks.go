@@ -0,0 +1,217 @@
+package upstream
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+)
+
+// SOCKS5（RFC 1928）与用户名/密码认证（RFC 1929）
+const (
+	socks5Version      = 0x05
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoMatch  = 0xff
+	socks5CmdConnect   = 0x01
+	socks5AtypIPv4     = 0x01
+	socks5AtypDomain   = 0x03
+	socks5AtypIPv6     = 0x04
+)
+
+var socks5Replies = map[byte]string{
+	0x01: "general failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// socks5Handshake 协商认证并发送 CONNECT；域名交给上游解析，避免本地 DNS 泄露
+func socks5Handshake(conn net.Conn, u *url.URL, target string) error {
+	host, port, err := splitTarget(target)
+	if err != nil {
+		return err
+	}
+
+	methods := []byte{socks5AuthNone}
+	if u.User != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return fmt.Errorf("socks5 greeting: %w", err)
+	}
+	var choice [2]byte
+	if _, err := io.ReadFull(conn, choice[:]); err != nil {
+		return fmt.Errorf("socks5 greeting reply: %w", err)
+	}
+	if choice[0] != socks5Version {
+		return fmt.Errorf("socks5: unexpected version %d", choice[0])
+	}
+	switch choice[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if err := socks5Auth(conn, u.User); err != nil {
+			return err
+		}
+	case socks5AuthNoMatch:
+		return errors.New("socks5: no acceptable auth method")
+	default:
+		return fmt.Errorf("socks5: unsupported auth method %d", choice[1])
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Is4() || ip.Is4In6() {
+			req = append(req, socks5AtypIPv4)
+			b := ip.Unmap().As4()
+			req = append(req, b[:]...)
+		} else {
+			req = append(req, socks5AtypIPv6)
+			b := ip.As16()
+			req = append(req, b[:]...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("socks5: host name too long")
+		}
+		req = append(req, socks5AtypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, port)
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("socks5 connect: %w", err)
+	}
+
+	var head [4]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return fmt.Errorf("socks5 connect reply: %w", err)
+	}
+	if head[1] != 0x00 {
+		msg := socks5Replies[head[1]]
+		if msg == "" {
+			msg = "code " + strconv.Itoa(int(head[1]))
+		}
+		return fmt.Errorf("socks5 connect failed: %s", msg)
+	}
+	// 丢弃 BND.ADDR / BND.PORT
+	var skip int
+	switch head[3] {
+	case socks5AtypIPv4:
+		skip = 4
+	case socks5AtypIPv6:
+		skip = 16
+	case socks5AtypDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return fmt.Errorf("socks5 connect reply: %w", err)
+		}
+		skip = int(l[0])
+	default:
+		return fmt.Errorf("socks5: bad address type %d in reply", head[3])
+	}
+	if _, err := io.CopyN(io.Discard, conn, int64(skip+2)); err != nil {
+		return fmt.Errorf("socks5 connect reply: %w", err)
+	}
+	return nil
+}
+
+// socks5Auth RFC 1929 用户名/密码子协商
+func socks5Auth(conn net.Conn, user *url.Userinfo) error {
+	name := user.Username()
+	pass, _ := user.Password()
+	if len(name) > 255 || len(pass) > 255 {
+		return errors.New("socks5: username or password too long")
+	}
+	b := []byte{0x01, byte(len(name))}
+	b = append(b, name...)
+	b = append(b, byte(len(pass)))
+	b = append(b, pass...)
+	if _, err := conn.Write(b); err != nil {
+		return fmt.Errorf("socks5 auth: %w", err)
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return fmt.Errorf("socks5 auth reply: %w", err)
+	}
+	if resp[1] != 0x00 {
+		return errors.New("socks5: authentication rejected")
+	}
+	return nil
+}
+
+// socks4Handshake SOCKS4 / SOCKS4a CONNECT。
+// socks4 只能携带 IPv4，域名在本地解析；socks4a 把域名交给上游解析。
+// 用户名作为 USERID 发送（SOCKS4 无密码概念）。
+func socks4Handshake(ctx context.Context, conn net.Conn, u *url.URL, target string) error {
+	host, port, err := splitTarget(target)
+	if err != nil {
+		return err
+	}
+
+	ip, err := netip.ParseAddr(host)
+	remoteResolve := false
+	if err != nil {
+		if u.Scheme == "socks4a" {
+			remoteResolve = true
+		} else {
+			addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip4", host)
+			if err != nil || len(addrs) == 0 {
+				return fmt.Errorf("socks4: resolve %q: %v", host, err)
+			}
+			ip = addrs[0]
+		}
+	}
+	if !remoteResolve && !ip.Unmap().Is4() {
+		return errors.New("socks4: only IPv4 targets are supported")
+	}
+
+	req := []byte{0x04, 0x01}
+	req = binary.BigEndian.AppendUint16(req, port)
+	if remoteResolve {
+		req = append(req, 0, 0, 0, 1) // 0.0.0.x 表示使用后面的域名
+	} else {
+		b := ip.Unmap().As4()
+		req = append(req, b[:]...)
+	}
+	if u.User != nil {
+		req = append(req, u.User.Username()...)
+	}
+	req = append(req, 0x00)
+	if remoteResolve {
+		req = append(req, host...)
+		req = append(req, 0x00)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("socks4 connect: %w", err)
+	}
+
+	var resp [8]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return fmt.Errorf("socks4 connect reply: %w", err)
+	}
+	if resp[1] != 0x5a {
+		return fmt.Errorf("socks4 connect failed: code 0x%02x", resp[1])
+	}
+	return nil
+}
+
+func splitTarget(target string) (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", 0, fmt.Errorf("bad target %q: %w", target, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("bad target port %q", portStr)
+	}
+	return host, uint16(port), nil
+}
@@ -0,0 +1,160 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// handshakeTimeout 与上游握手（CONNECT / SOCKS 协商）的最长时间，避免卡死
+const handshakeTimeout = 12 * time.Second
+
+// ErrUnsupportedScheme 上游地址的 scheme 不受支持
+var ErrUnsupportedScheme = errors.New("unsupported upstream scheme")
+
+// Parse 解析上游地址：支持 http://、https://（TLS 连接上游）、socks5://、socks5h://、socks4://、socks4a://，
+// 以及无 scheme 的 "user:pass@host:port" / "host:port"（按 http 处理）。
+func Parse(addr string) (*url.URL, error) {
+	raw := addr
+	if !strings.Contains(addr, "://") {
+		raw = "http://" + addr
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in upstream %q", addr)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h", "socks4", "socks4a":
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedScheme, u.Scheme)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), defaultPort(u.Scheme))
+	}
+	return u, nil
+}
+
+func defaultPort(scheme string) string {
+	switch {
+	case strings.HasPrefix(scheme, "socks"):
+		return "1080"
+	case scheme == "https":
+		return "443"
+	}
+	return "80"
+}
+
+// Options 拨号参数
+type Options struct {
+	Timeout time.Duration // TCP 建连超时
+	TLS     *tls.Config   // https 上游的 TLS 配置；nil 使用系统根证书。ServerName 为空时取上游主机名
+}
+
+// TLSConfig 构造 https 上游使用的 TLS 配置：caFile 为 PEM 格式的额外 CA，
+// serverName 覆盖 SNI（同时用于证书校验），insecure 跳过证书校验。全为空时返回 nil。
+func TLSConfig(caFile, serverName string, insecure bool) (*tls.Config, error) {
+	if caFile == "" && serverName == "" && !insecure {
+		return nil, nil
+	}
+	cfg := &tls.Config{ServerName: serverName, InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// Dial 经由上游 u 建立到 target（host:port）的 TCP 隧道。
+// opts.Timeout 约束 TCP 建连；握手阶段（含 TLS）受 ctx 截止时间与 handshakeTimeout 共同约束，成功后清除 deadline。
+func Dial(ctx context.Context, u *url.URL, target string, opts Options) (net.Conn, error) {
+	conn, err := dial(ctx, u, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel := conn
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		err = socks5Handshake(conn, u, target)
+	case "socks4", "socks4a":
+		err = socks4Handshake(ctx, conn, u, target)
+	default:
+		tunnel, err = connectHandshake(conn, u, target)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	// 隧道建立成功后清理 deadline，交给后续长连接
+	_ = conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// DialTLS 与 https 上游建立 TLS 连接，不发 CONNECT：普通 http:// 请求在这条连接上
+// 直接以绝对 URI 发给上游，与 http 上游的转发方式相同
+func DialTLS(ctx context.Context, u *url.URL, opts Options) (net.Conn, error) {
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("upstream scheme %q is not https", u.Scheme)
+	}
+	conn, err := dial(ctx, u, opts)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// dial 建立到上游的 TCP 连接，https 上游再完成 TLS 握手；返回的连接仍带着握手阶段的 deadline
+func dial(ctx context.Context, u *url.URL, opts Options) (net.Conn, error) {
+	d := &net.Dialer{Timeout: opts.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(handshakeTimeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	_ = conn.SetDeadline(deadline)
+
+	if u.Scheme == "https" {
+		var cfg *tls.Config
+		if opts.TLS != nil {
+			cfg = opts.TLS.Clone()
+		} else {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("tls handshake with upstream: %w", err)
+		}
+		conn = tc
+	}
+	return conn, nil
+}